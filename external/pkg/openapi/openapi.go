@@ -0,0 +1,212 @@
+// Package openapi generates an OpenAPI 3 document from routes registered with request.Router.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bravpei/webtools/external/pkg/request"
+	"github.com/bravpei/webtools/external/pkg/response"
+	"github.com/kataras/iris/v12"
+)
+
+// Version of the generated document
+const Version = "3.0.3"
+
+// Info is the document's info object.
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Document is an OpenAPI 3 document.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// PathItem maps lower-case HTTP methods to operations.
+type PathItem map[string]*Operation
+
+// Components holds the reusable schemas.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Operation describes a single API operation.
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []*Parameter        `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter is a query, path or header parameter.
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody describes the request body by media type.
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response by media type.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a media type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a subset of the OpenAPI schema object.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+}
+
+// pathParamPattern matches iris path parameters such as {id:int64}.
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(?::([^}]*))?\}`)
+
+// Build generates the document for the routes.
+func Build(info Info, routes []request.RouteInfo) *Document {
+	g := newGenerator()
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]PathItem{},
+	}
+	envelope := g.schema(reflect.TypeOf(response.Result{}))
+
+	for _, route := range routes {
+		path, macros := convertPath(route.Path)
+		op := &Operation{
+			OperationID: operationID(route.Method, path),
+			Summary:     route.Summary,
+			Tags:        route.Tags,
+			Responses: map[string]Response{
+				"200": {Description: "OK", Content: jsonContent(&Schema{AllOf: []*Schema{
+					envelope,
+					{Type: "object", Properties: map[string]*Schema{"data": g.schema(route.Data)}},
+				}})},
+				"default": {Description: "Error", Content: jsonContent(envelope)},
+			},
+		}
+		g.params(op, route.Method, route.Params)
+
+		// Path parameters not bound into Params are documented from their iris macro
+		for name, macro := range macros {
+			if !hasParameter(op, name, "path") {
+				op.Parameters = append(op.Parameters, &Parameter{Name: name, In: "path", Required: true, Schema: macroSchema(macro)})
+			}
+		}
+		sort.SliceStable(op.Parameters, func(i, j int) bool {
+			if op.Parameters[i].In != op.Parameters[j].In {
+				return parameterOrder[op.Parameters[i].In] < parameterOrder[op.Parameters[j].In]
+			}
+			return op.Parameters[i].Name < op.Parameters[j].Name
+		})
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = PathItem{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = op
+	}
+	doc.Components.Schemas = g.components
+	return doc
+}
+
+// Serve registers a GET endpoint on party that returns the document of the router's routes.
+// The document is rebuilt on every request so routes registered later are included.
+func Serve(party iris.Party, path string, info Info, router *request.Router) {
+	party.Get(path, func(ctx iris.Context) {
+		_ = ctx.JSON(Build(info, router.Routes()))
+	})
+}
+
+var parameterOrder = map[string]int{"path": 0, "query": 1, "header": 2}
+
+func jsonContent(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: schema}}
+}
+
+// convertPath turns an iris path template into an OpenAPI path, returning the parameter macros.
+func convertPath(path string) (string, map[string]string) {
+	macros := map[string]string{}
+	converted := pathParamPattern.ReplaceAllStringFunc(path, func(m string) string {
+		sub := pathParamPattern.FindStringSubmatch(m)
+		macros[sub[1]] = sub[2]
+		return "{" + sub[1] + "}"
+	})
+	return converted, macros
+}
+
+func macroSchema(macro string) *Schema {
+	switch {
+	case strings.HasPrefix(macro, "int"), strings.HasPrefix(macro, "uint"):
+		return &Schema{Type: "integer"}
+	case strings.HasPrefix(macro, "bool"):
+		return &Schema{Type: "boolean"}
+	default:
+		return &Schema{Type: "string"}
+	}
+}
+
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	upper := true
+	for _, c := range path {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			if upper {
+				b.WriteString(strings.ToUpper(string(c)))
+				upper = false
+			} else {
+				b.WriteRune(c)
+			}
+			continue
+		}
+		upper = true
+	}
+	return b.String()
+}
+
+func hasParameter(op *Operation, name, in string) bool {
+	for _, p := range op.Parameters {
+		if p.Name == name && p.In == in {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyMethods are the methods whose JSON fields are documented as a request body;
+// ControllerTemplate binds GET requests from the query string instead.
+var bodyMethods = map[string]bool{http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true}
@@ -0,0 +1,126 @@
+package openapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bravpei/webtools/external/pkg/page"
+	"github.com/bravpei/webtools/external/pkg/request"
+	"github.com/kataras/iris/v12"
+)
+
+type user struct {
+	ID    int64   `json:"id"`
+	Name  string  `json:"name"`
+	Email *string `json:"email"`
+}
+
+type listUsersParams struct {
+	Page   int    `query:"page" validate:"required|min:1"`
+	Size   int    `query:"size" validate:"between:1,100"`
+	Tenant string `header:"X-Tenant"`
+}
+
+type createUserParams struct {
+	Tenant string `header:"X-Tenant" validate:"required"`
+	Name   string `json:"name" validate:"required|minLen:2|maxLen:32"`
+	Role   string `json:"role" validate:"in:admin,member"`
+}
+
+func newTestRouter() (*iris.Application, *request.Router) {
+	app := iris.New()
+	router := request.NewRouter(app.Party("/api"))
+	users := router.Party("/users")
+	request.HandleOf(users, http.MethodGet, "/", func(ctx context.Context, p listUsersParams) request.TypedResult[page.Page[user]] {
+		return request.TypedResult[page.Page[user]]{}
+	}, request.WithSummary("List users"), request.WithTags("users"))
+	request.HandleOf(users, http.MethodPost, "/", func(ctx context.Context, p createUserParams) request.TypedResult[user] {
+		return request.TypedResult[user]{}
+	})
+	request.Handle(users, http.MethodDelete, "/{id:int64}", func(ctx context.Context, p struct{}) request.BusinessResult {
+		return request.BusinessResult{}
+	})
+	return app, router
+}
+
+func TestBuild(t *testing.T) {
+	_, router := newTestRouter()
+	doc := Build(Info{Title: "test", Version: "1.0"}, router.Routes())
+
+	list := doc.Paths["/api/users"]["get"]
+	if list == nil {
+		t.Fatalf("missing GET /api/users: %v", doc.Paths)
+	}
+	if list.Summary != "List users" || len(list.Tags) != 1 {
+		t.Errorf("summary %q tags %v", list.Summary, list.Tags)
+	}
+	if len(list.Parameters) != 3 || list.Parameters[0].Name != "page" || !list.Parameters[0].Required ||
+		*list.Parameters[0].Schema.Minimum != 1 || list.Parameters[2].In != "header" {
+		t.Errorf("parameters %+v", list.Parameters)
+	}
+	if size := list.Parameters[1].Schema; *size.Minimum != 1 || *size.Maximum != 100 {
+		t.Errorf("size schema %+v", size)
+	}
+	if list.RequestBody != nil {
+		t.Error("GET has a request body")
+	}
+	ok := list.Responses["200"].Content["application/json"].Schema
+	if len(ok.AllOf) != 2 || ok.AllOf[0].Ref != componentPrefix+"Result" ||
+		ok.AllOf[1].Properties["data"].Ref != componentPrefix+"Page_user" {
+		t.Errorf("200 schema %+v", ok)
+	}
+	if p := doc.Components.Schemas["Page_user"]; p == nil || p.Properties["content"] == nil {
+		t.Errorf("Page_user component %+v", p)
+	}
+	if u := doc.Components.Schemas["user"]; u == nil || !u.Properties["email"].Nullable {
+		t.Errorf("user component %+v", u)
+	}
+
+	create := doc.Paths["/api/users"]["post"]
+	body := create.RequestBody.Content["application/json"].Schema
+	if len(body.Required) != 1 || body.Required[0] != "name" || body.Properties["X-Tenant"] != nil {
+		t.Errorf("body %+v", body)
+	}
+	if name := body.Properties["name"]; *name.MinLength != 2 || *name.MaxLength != 32 {
+		t.Errorf("name schema %+v", name)
+	}
+	if role := body.Properties["role"]; len(role.Enum) != 2 {
+		t.Errorf("role schema %+v", role)
+	}
+
+	del := doc.Paths["/api/users/{id}"]["delete"]
+	if del == nil || len(del.Parameters) != 1 || del.Parameters[0].In != "path" || del.Parameters[0].Schema.Type != "integer" {
+		t.Fatalf("DELETE /api/users/{id}: %+v", del)
+	}
+	if del.OperationID != "deleteApiUsersId" {
+		t.Errorf("operation id %q", del.OperationID)
+	}
+	// Untyped results document data without a schema
+	if data := del.Responses["200"].Content["application/json"].Schema.AllOf[1].Properties["data"]; data.Type != "" || data.Ref != "" {
+		t.Errorf("untyped data schema %+v", data)
+	}
+}
+
+func TestServe(t *testing.T) {
+	app, router := newTestRouter()
+	Serve(app, "/openapi.json", Info{Title: "test", Version: "1.0"}, router)
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var doc Document
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != Version || len(doc.Paths) != 2 {
+		t.Errorf("document %+v", doc)
+	}
+}
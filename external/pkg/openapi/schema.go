@@ -0,0 +1,339 @@
+package openapi
+
+import (
+	"encoding/json"
+	"mime/multipart"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const componentPrefix = "#/components/schemas/"
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	fileHeaderType = reflect.TypeOf(multipart.FileHeader{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+	// qualifiedName strips package paths from generic type arguments, e.g. Page[example.com/x.User]
+	qualifiedName = regexp.MustCompile(`[\w./-]+\.`)
+)
+
+// bindingTags are the struct tags ControllerTemplate binds from outside the body,
+// mapped to the OpenAPI parameter location. form fields go into a form body.
+var bindingTags = []struct{ tag, in string }{
+	{"path", "path"},
+	{"query", "query"},
+	{"url", "query"},
+	{"header", "header"},
+	{"form", "form"},
+}
+
+// generator builds schemas and collects named struct types as components.
+type generator struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newGenerator() *generator {
+	return &generator{components: map[string]*Schema{}, names: map[reflect.Type]string{}}
+}
+
+// schema returns the schema of t; named structs become component references.
+func (g *generator) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if t.Kind() == reflect.Ptr {
+		s := g.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case fileHeaderType:
+		return &Schema{Type: "string", Format: "binary"}
+	case rawMessageType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: float(0)}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t, false)
+		}
+		name := g.name(t)
+		if _, ok := g.components[name]; !ok {
+			// Register before descending so recursive types terminate
+			placeholder := &Schema{}
+			g.components[name] = placeholder
+			*placeholder = *g.structSchema(t, false)
+		}
+		return &Schema{Ref: componentPrefix + name}
+	default:
+		return &Schema{}
+	}
+}
+
+// name returns a unique component name for a named type.
+func (g *generator) name(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := qualifiedName.ReplaceAllString(t.Name(), "")
+	name = strings.Trim(strings.NewReplacer("[", "_", "]", "", ",", "_", " ", "", "*", "").Replace(name), "_")
+	base := name
+	for i := 2; ; i++ {
+		if !g.nameTaken(name) {
+			break
+		}
+		name = base + strconv.Itoa(i)
+	}
+	g.names[t] = name
+	return name
+}
+
+func (g *generator) nameTaken(name string) bool {
+	for _, n := range g.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// structSchema builds an object schema from json fields. With bodyOnly, fields bound
+// from path, query, header or form are left out.
+func (g *generator) structSchema(t reflect.Type, bodyOnly bool) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	walkFields(t, func(f reflect.StructField) {
+		if bodyOnly && bindingLocation(f) != "" {
+			return
+		}
+		name, omit := jsonName(f)
+		if omit {
+			return
+		}
+		prop := g.schema(f.Type)
+		if applyRules(prop, f.Type, f.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	})
+	return s
+}
+
+// params documents the parameters and request body of a Params type.
+func (g *generator) params(op *Operation, method string, t reflect.Type) {
+	if t == nil {
+		return
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+
+	form := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	multipartForm := false
+	walkFields(t, func(f reflect.StructField) {
+		in := bindingLocation(f)
+		if in == "" {
+			return
+		}
+		name := tagName(f, in)
+		prop := g.schema(f.Type)
+		required := applyRules(prop, f.Type, f.Tag.Get("validate"))
+
+		if in == "form" {
+			form.Properties[name] = prop
+			if required {
+				form.Required = append(form.Required, name)
+			}
+			if prop.Format == "binary" || prop.Items != nil && prop.Items.Format == "binary" {
+				multipartForm = true
+			}
+			return
+		}
+		op.Parameters = append(op.Parameters, &Parameter{Name: name, In: in, Required: required || in == "path", Schema: prop})
+	})
+
+	switch {
+	case len(form.Properties) > 0:
+		mediaType := "application/x-www-form-urlencoded"
+		if multipartForm {
+			mediaType = "multipart/form-data"
+		}
+		op.RequestBody = &RequestBody{Required: len(form.Required) > 0, Content: map[string]MediaType{mediaType: {Schema: form}}}
+	case bodyMethods[method]:
+		if body := g.structSchema(t, true); len(body.Properties) > 0 {
+			op.RequestBody = &RequestBody{Required: len(body.Required) > 0, Content: jsonContent(body)}
+		}
+	}
+}
+
+// walkFields visits exported fields, flattening embedded structs without a json name.
+func walkFields(t reflect.Type, visit func(f reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); ft.Kind() == reflect.Struct && name == "" {
+				walkFields(ft, visit)
+				continue
+			}
+		}
+		if f.IsExported() {
+			visit(f)
+		}
+	}
+}
+
+// bindingLocation returns where ControllerTemplate binds the field from, or "" for the body.
+func bindingLocation(f reflect.StructField) string {
+	for _, b := range bindingTags {
+		if name := tagName(f, b.tag); name != "" && name != "-" {
+			return b.in
+		}
+	}
+	return ""
+}
+
+// tagName returns the name in the binding tag for location in.
+func tagName(f reflect.StructField, in string) string {
+	for _, b := range bindingTags {
+		if b.in != in {
+			continue
+		}
+		if name, _, _ := strings.Cut(f.Tag.Get(b.tag), ","); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func jsonName(f reflect.StructField) (name string, omit bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	name, _, _ = strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, false
+}
+
+// applyRules maps gookit/validate rules onto the schema and reports whether the field is required.
+func applyRules(s *Schema, t reflect.Type, rules string) (required bool) {
+	if rules == "" {
+		return false
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	numeric := isNumeric(t.Kind())
+
+	for _, rule := range strings.Split(rules, "|") {
+		name, args, _ := strings.Cut(strings.TrimSpace(rule), ":")
+		values := strings.Split(args, ",")
+		switch strings.ToLower(name) {
+		case "required":
+			required = true
+		case "min":
+			setMin(s, numeric, values[0])
+		case "max":
+			setMax(s, numeric, values[0])
+		case "between", "range":
+			if len(values) == 2 {
+				setMin(s, numeric, values[0])
+				setMax(s, numeric, values[1])
+			}
+		case "minlen", "minlength":
+			setMin(s, false, values[0])
+		case "maxlen", "maxlength":
+			setMax(s, false, values[0])
+		case "len", "length":
+			setMin(s, false, values[0])
+			setMax(s, false, values[0])
+		case "in", "enum":
+			for _, v := range values {
+				if n, err := strconv.ParseFloat(v, 64); err == nil && numeric {
+					s.Enum = append(s.Enum, n)
+				} else {
+					s.Enum = append(s.Enum, v)
+				}
+			}
+		case "email":
+			s.Format = "email"
+		case "url", "fullurl", "isurl":
+			s.Format = "uri"
+		case "uuid", "isuuid":
+			s.Format = "uuid"
+		case "date", "isdate":
+			s.Format = "date"
+		case "ip", "isip":
+			s.Format = "ip"
+		}
+	}
+	return required
+}
+
+func isNumeric(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
+
+func setMin(s *Schema, numeric bool, value string) {
+	if numeric {
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			s.Minimum = &n
+		}
+	} else if n, err := strconv.Atoi(value); err == nil && s.Type == "string" {
+		s.MinLength = &n
+	}
+}
+
+func setMax(s *Schema, numeric bool, value string) {
+	if numeric {
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			s.Maximum = &n
+		}
+	} else if n, err := strconv.Atoi(value); err == nil && s.Type == "string" {
+		s.MaxLength = &n
+	}
+}
+
+func float(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,281 @@
+package page
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CountMode 总数统计方式
+type CountMode string
+
+const (
+	// CountExact 执行 COUNT 子查询，默认方式
+	CountExact CountMode = "exact"
+	// CountNone 不统计总数，多取一条判断是否有下一页
+	CountNone CountMode = "none"
+	// CountCached 相同查询条件在 TTL 内复用总数，见 SetCountCacheTTL
+	CountCached CountMode = "cached"
+	// CountApproximate 使用 MySQL/Postgres 的执行计划估算总数，其他数据库退化为 CountExact
+	CountApproximate CountMode = "approximate"
+)
+
+func (m CountMode) valid() bool {
+	switch m {
+	case "", CountExact, CountNone, CountCached, CountApproximate:
+		return true
+	}
+	return false
+}
+
+// exactCount 将查询作为子查询统计总数
+func exactCount(query *gorm.DB) (total int64, err error) {
+	countQuery := query.Session(&gorm.Session{})
+	newQuery := query.Session(&gorm.Session{NewDB: true}).Limit(-1).Offset(-1)
+	err = newQuery.Table("(?) as countQuery", countQuery).Count(&total).Error
+	return
+}
+
+// defaultCountCacheSize CountCached 模式默认最多缓存的查询数
+const defaultCountCacheSize = 10000
+
+type countEntry struct {
+	key     string
+	total   int64
+	expires time.Time
+}
+
+var (
+	countCacheMu   sync.Mutex
+	countCache     = map[string]*list.Element{}
+	countCacheList = list.New() // 按写入时间排列，尾部最早写入、最先过期
+	countCacheTTL  = time.Minute
+	countCacheSize = defaultCountCacheSize
+)
+
+// SetCountCacheTTL 设置 CountCached 模式下总数的缓存时间，默认 1 分钟
+func SetCountCacheTTL(ttl time.Duration) {
+	countCacheMu.Lock()
+	defer countCacheMu.Unlock()
+	countCacheTTL = ttl
+}
+
+// SetCountCacheSize 设置 CountCached 模式最多缓存的查询数，超出时淘汰最早写入的条目，默认 10000
+func SetCountCacheSize(size int) {
+	countCacheMu.Lock()
+	defer countCacheMu.Unlock()
+	countCacheSize = max(size, 1)
+	trimCountCache(time.Now())
+}
+
+// cachedCount 以数据库类型和不含分页的完整 SQL 作为缓存键
+func cachedCount[T any](query *gorm.DB) (int64, error) {
+	key := query.Dialector.Name() + ":" + query.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return tx.Limit(-1).Offset(-1).Find(&[]T{})
+	})
+
+	countCacheMu.Lock()
+	if elem, ok := countCache[key]; ok {
+		if entry := elem.Value.(*countEntry); time.Now().Before(entry.expires) {
+			countCacheMu.Unlock()
+			return entry.total, nil
+		}
+	}
+	countCacheMu.Unlock()
+
+	total, err := exactCount(query)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	countCacheMu.Lock()
+	defer countCacheMu.Unlock()
+	if elem, ok := countCache[key]; ok {
+		removeCount(elem)
+	}
+	countCache[key] = countCacheList.PushFront(&countEntry{key: key, total: total, expires: now.Add(countCacheTTL)})
+	trimCountCache(now)
+	return total, nil
+}
+
+// trimCountCache 从尾部移除过期和超出上限的条目，遇到未过期的条目即停止，
+// 每个条目只会被移除一次，写入的开销均摊为 O(1)；需持有 countCacheMu
+func trimCountCache(now time.Time) {
+	for elem := countCacheList.Back(); elem != nil; elem = countCacheList.Back() {
+		if countCacheList.Len() <= countCacheSize && now.Before(elem.Value.(*countEntry).expires) {
+			return
+		}
+		removeCount(elem)
+	}
+}
+
+func removeCount(elem *list.Element) {
+	countCacheList.Remove(elem)
+	delete(countCache, elem.Value.(*countEntry).key)
+}
+
+// approximateCount 通过 EXPLAIN 估算总数，ok 为 false 表示当前数据库不支持
+func approximateCount[T any](query *gorm.DB) (total int64, ok bool, err error) {
+	var explain string
+	switch query.Dialector.Name() {
+	case "mysql":
+		explain = "EXPLAIN "
+	case "postgres":
+		explain = "EXPLAIN (FORMAT JSON) "
+	default:
+		return 0, false, nil
+	}
+
+	stmt := query.Session(&gorm.Session{DryRun: true}).Limit(-1).Offset(-1).Find(&[]T{}).Statement
+	if stmt.Error != nil {
+		return 0, false, stmt.Error
+	}
+	// 直接使用连接执行，保留方言生成的占位符
+	rows, err := query.Statement.ConnPool.QueryContext(query.Statement.Context, explain+stmt.SQL.String(), stmt.Vars...)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	if query.Dialector.Name() == "postgres" {
+		total, err = postgresEstimate(rows)
+	} else {
+		total, err = mysqlEstimate(rows)
+	}
+	return total, err == nil, err
+}
+
+// mysqlEstimate 取执行计划第一行的 rows * filtered%
+func mysqlEstimate(rows *sql.Rows) (int64, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	estimate, filtered := 0.0, 100.0
+	for i, column := range columns {
+		switch strings.ToLower(column) {
+		case "rows":
+			estimate, _ = strconv.ParseFloat(values[i].String, 64)
+		case "filtered":
+			if f, err := strconv.ParseFloat(values[i].String, 64); err == nil {
+				filtered = f
+			}
+		}
+	}
+	return int64(math.Round(estimate * filtered / 100)), nil
+}
+
+// postgresEstimate 取 JSON 执行计划根节点的 Plan Rows
+func postgresEstimate(rows *sql.Rows) (int64, error) {
+	if !rows.Next() {
+		return 0, rows.Err()
+	}
+	var raw []byte
+	if err := rows.Scan(&raw); err != nil {
+		return 0, err
+	}
+	var plans []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil || len(plans) == 0 {
+		return 0, err
+	}
+	return int64(plans[0].Plan.Rows), nil
+}
+
+// countFunc 统计总数，precise 表示总数准确，可据此判断请求页是否为空
+type countFunc func(query *gorm.DB) (total int64, precise bool, err error)
+
+// counter 返回统计方式对应的统计函数，CountNone 返回 nil
+func counter[T any](mode CountMode) countFunc {
+	switch mode {
+	case CountExact:
+		return func(query *gorm.DB) (int64, bool, error) {
+			total, err := exactCount(query)
+			return total, true, err
+		}
+	case CountCached:
+		// 缓存的总数可能过期，不用于跳过数据查询
+		return func(query *gorm.DB) (int64, bool, error) {
+			total, err := cachedCount[T](query)
+			return total, false, err
+		}
+	case CountApproximate:
+		return func(query *gorm.DB) (int64, bool, error) {
+			total, ok, err := approximateCount[T](query)
+			if err == nil && !ok {
+				total, err = exactCount(query)
+				return total, true, err
+			}
+			return total, false, err
+		}
+	}
+	return nil
+}
+
+// inTransaction 判断查询是否在事务中，事务只有一个连接，不能并发执行两个查询
+func inTransaction(query *gorm.DB) bool {
+	switch query.Statement.ConnPool.(type) {
+	case *sql.Tx, gorm.TxCommitter:
+		return true
+	}
+	return false
+}
+
+// countAndFetch 在共享同一可取消 context 的两个会话上并发执行统计和数据查询。
+// 一方出错时取消另一方；总数确定请求页为空时取消数据查询并忽略其结果。
+func countAndFetch(query *gorm.DB, count countFunc, fetch func(*gorm.DB) error, beyond func(total int64) bool) (total int64, precise bool, err error) {
+	ctx, cancel := context.WithCancel(query.Statement.Context)
+	defer cancel()
+	countQuery := query.Session(&gorm.Session{Context: ctx})
+	fetchQuery := query.Session(&gorm.Session{Context: ctx})
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		total, precise, err = count(countQuery)
+		if err != nil || (precise && beyond(total)) {
+			cancel()
+		}
+		done <- err
+	}()
+
+	fetchErr := fetch(fetchQuery)
+	if fetchErr != nil {
+		cancel()
+	}
+	err = <-done
+	switch {
+	case err != nil && fetchErr != nil && errors.Is(err, context.Canceled):
+		// 统计查询因数据查询出错被取消
+		return total, precise, fetchErr
+	case err != nil:
+		return total, precise, err
+	case precise && beyond(total):
+		return total, precise, nil
+	}
+	return total, precise, fetchErr
+}
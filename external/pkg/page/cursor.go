@@ -0,0 +1,266 @@
+package page
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bravpei/webtools/external/pkg/response"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// CodeCursorInvalid 游标被篡改、格式错误或与排序列不匹配
+var CodeCursorInvalid = response.Register(response.ErrorCode{
+	Code: "CURSOR_INVALID", Message: "invalid page cursor",
+	HTTPStatus: http.StatusBadRequest, Status: response.StatusValidationError,
+})
+
+func init() {
+	response.RegisterCatalog("zh-CN", response.Catalog{Messages: map[string]string{
+		CodeCursorInvalid.Code: "分页游标无效",
+	}})
+
+	// 默认密钥随进程生成，重启或多实例部署时应通过 SetCursorSecret 设置固定密钥
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	SetCursorSecret(secret)
+}
+
+var cursorSecret atomic.Pointer[[]byte]
+
+// SetCursorSecret 设置游标签名密钥
+func SetCursorSecret(secret []byte) {
+	secret = append([]byte(nil), secret...)
+	cursorSecret.Store(&secret)
+}
+
+// OrderColumn 游标分页的排序列，Column 为数据库列名或结构体字段名，可带表名前缀如 users.id。
+// 排序列不能为 NULL，且组合起来必须唯一，通常以主键作为最后一列。
+type OrderColumn struct {
+	Column string
+	Desc   bool
+}
+
+// CursorReq 游标分页参数，Cursor 为空时返回第一页
+type CursorReq struct {
+	Cursor string `json:"cursor" query:"cursor"`
+	Size   int    `json:"size" query:"size" validate:"required|min:1|max:100000"`
+}
+
+// CursorPage 游标分页结果，HasMore 表示请求方向上是否还有数据
+type CursorPage[T any] struct {
+	Content    []T    `json:"content"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// cursor 游标内容，Values 按排序列顺序保存边界行的值
+type cursor struct {
+	Order    string            `json:"o"`
+	Backward bool              `json:"b,omitempty"`
+	Values   []json.RawMessage `json:"v"`
+}
+
+// orderField 解析后的排序列
+type orderField struct {
+	column clause.Column
+	desc   bool
+	field  *schema.Field
+}
+
+var schemaCache sync.Map
+
+// CursorTemplate 游标（keyset）分页查询模板函数，按 order 排序，不执行 count 查询。
+// handler 返回的查询不应包含 Order、Limit 和 Offset。
+func CursorTemplate[T any](req CursorReq, order []OrderColumn, handler func() (*gorm.DB, error)) (page CursorPage[T], err error) {
+	if req.Size < 1 || req.Size > maxPageSize {
+		err = CodePageSizeInvalid.Err(1, maxPageSize)
+		return
+	}
+	if len(order) == 0 {
+		err = fmt.Errorf("page: no order columns")
+		return
+	}
+
+	query, err := handler()
+	if err != nil {
+		return
+	}
+	fields, err := parseOrder[T](query, order)
+	if err != nil {
+		return
+	}
+	signature := orderSignature(order)
+
+	var cur *cursor
+	if req.Cursor != "" {
+		if cur, err = decodeCursor(req.Cursor, signature, len(fields)); err != nil {
+			return
+		}
+	}
+	backward := cur != nil && cur.Backward
+
+	if cur != nil {
+		var values []any
+		if values, err = cursorValues(cur, fields); err != nil {
+			return
+		}
+		query = query.Where(keysetCondition(fields, values, backward))
+	}
+	// 向前翻页时反转排序，取出后再恢复顺序
+	for _, f := range fields {
+		query = query.Order(clause.OrderByColumn{Column: f.column, Desc: f.desc != backward})
+	}
+
+	// 多取一条判断是否还有数据
+	results := make([]T, 0, req.Size+1)
+	if err = query.Limit(req.Size + 1).Find(&results).Error; err != nil {
+		return
+	}
+	hasMore := len(results) > req.Size
+	if hasMore {
+		results = results[:req.Size]
+	}
+	if backward {
+		for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+			results[i], results[j] = results[j], results[i]
+		}
+	}
+
+	page = CursorPage[T]{Content: results, HasMore: hasMore}
+	if len(results) == 0 {
+		return
+	}
+	ctx := query.Statement.Context
+	first, last := &results[0], &results[len(results)-1]
+	// 向后翻页时，有更多数据才有下一页；从游标开始的页总有上一页。向前翻页反之
+	if hasMore || backward {
+		if page.NextCursor, err = encodeCursor(ctx, last, fields, signature, false); err != nil {
+			return
+		}
+	}
+	if (hasMore && backward) || (cur != nil && !backward) {
+		if page.PrevCursor, err = encodeCursor(ctx, first, fields, signature, true); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// parseOrder 通过 gorm schema 将排序列解析为列名和结构体字段
+func parseOrder[T any](db *gorm.DB, order []OrderColumn) ([]orderField, error) {
+	sch, err := schema.Parse(new(T), &schemaCache, db.NamingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]orderField, len(order))
+	for i, o := range order {
+		table, name, ok := strings.Cut(o.Column, ".")
+		if !ok {
+			table, name = "", o.Column
+		}
+		field := sch.LookUpField(name)
+		if field == nil || field.DBName == "" {
+			return nil, fmt.Errorf("page: order column %q not found in %s", o.Column, sch.Name)
+		}
+		fields[i] = orderField{column: clause.Column{Table: table, Name: field.DBName}, desc: o.Desc, field: field}
+	}
+	return fields, nil
+}
+
+// keysetCondition 构造 (c1 > v1) OR (c1 = v1 AND c2 > v2) ... 条件，降序列或向前翻页时比较方向相反
+func keysetCondition(fields []orderField, values []any, backward bool) clause.Expression {
+	var or []clause.Expression
+	for i, f := range fields {
+		and := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			and = append(and, clause.Eq{Column: fields[j].column, Value: values[j]})
+		}
+		if f.desc != backward {
+			and = append(and, clause.Lt{Column: f.column, Value: values[i]})
+		} else {
+			and = append(and, clause.Gt{Column: f.column, Value: values[i]})
+		}
+		or = append(or, clause.And(and...))
+	}
+	return clause.Or(or...)
+}
+
+func orderSignature(order []OrderColumn) string {
+	parts := make([]string, len(order))
+	for i, o := range order {
+		parts[i] = o.Column
+		if o.Desc {
+			parts[i] = "-" + o.Column
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// encodeCursor 将行的排序列值编码为签名游标：base64url(HMAC-SHA256 || JSON)
+func encodeCursor[T any](ctx context.Context, row *T, fields []orderField, signature string, backward bool) (string, error) {
+	rv := reflect.ValueOf(row).Elem()
+	cur := cursor{Order: signature, Backward: backward, Values: make([]json.RawMessage, len(fields))}
+	for i, f := range fields {
+		value, _ := f.field.ValueOf(ctx, rv)
+		b, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		cur.Values[i] = b
+	}
+	payload, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(append(sign(payload), payload...)), nil
+}
+
+func decodeCursor(s, signature string, columns int) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) <= sha256.Size {
+		return nil, CodeCursorInvalid.Err()
+	}
+	mac, payload := data[:sha256.Size], data[sha256.Size:]
+	if !hmac.Equal(mac, sign(payload)) {
+		return nil, CodeCursorInvalid.Err()
+	}
+	var cur cursor
+	if err = json.Unmarshal(payload, &cur); err != nil || cur.Order != signature || len(cur.Values) != columns {
+		return nil, CodeCursorInvalid.Err()
+	}
+	return &cur, nil
+}
+
+// cursorValues 按字段类型解码游标值，保证 int64、time.Time 等类型不失真
+func cursorValues(cur *cursor, fields []orderField) ([]any, error) {
+	values := make([]any, len(fields))
+	for i, f := range fields {
+		ptr := reflect.New(f.field.FieldType)
+		if err := json.Unmarshal(cur.Values[i], ptr.Interface()); err != nil {
+			return nil, CodeCursorInvalid.Err()
+		}
+		values[i] = ptr.Elem().Interface()
+	}
+	return values, nil
+}
+
+func sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, *cursorSecret.Load())
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,103 @@
+package page
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bravpei/webtools/external/pkg/response"
+	"gorm.io/gorm"
+)
+
+type article struct {
+	ID        int64     `page:"filter"`
+	Title     string    `page:"filter,sort"`
+	CreatedAt time.Time `json:"created" page:"filter,sort"`
+}
+
+var articleOrder = []OrderColumn{{Column: "created_at", Desc: true}, {Column: "id"}}
+
+func TestCursorTemplate(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := []article{{ID: 3, CreatedAt: now}, {ID: 2, CreatedAt: now}, {ID: 1, CreatedAt: now.Add(-time.Hour)}}
+	db := newTestDB(t, rows)
+	query := func() (*gorm.DB, error) { return db.Model(&article{}), nil }
+	sql := &db.sql
+
+	first, err := CursorTemplate[article](CursorReq{Size: 2}, articleOrder, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Content) != 2 || !first.HasMore || first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("first page %+v", first)
+	}
+	if !strings.Contains(*sql, "ORDER BY `created_at` DESC,`id` LIMIT 3") || strings.Contains(*sql, "WHERE") {
+		t.Fatalf("first page sql %s", *sql)
+	}
+
+	// 第二页从第一页最后一行 (now, 2) 之后开始
+	db = newTestDB(t, rows[2:])
+	sql = &db.sql
+	second, err := CursorTemplate[article](CursorReq{Cursor: first.NextCursor, Size: 2}, articleOrder, func() (*gorm.DB, error) {
+		return db.Model(&article{}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(*sql, "WHERE (`created_at` < \"2024-05-01 00:00:00\" OR (`created_at` = \"2024-05-01 00:00:00\" AND `id` > 2))") {
+		t.Fatalf("second page sql %s", *sql)
+	}
+	if second.HasMore || second.NextCursor != "" || second.PrevCursor == "" {
+		t.Fatalf("second page %+v", second)
+	}
+
+	// 向前翻页时比较方向和排序都反转，结果恢复原顺序
+	db = newTestDB(t, []article{rows[1], rows[0]})
+	sql = &db.sql
+	prev, err := CursorTemplate[article](CursorReq{Cursor: second.PrevCursor, Size: 2}, articleOrder, func() (*gorm.DB, error) {
+		return db.Model(&article{}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(*sql, "`id` < 1)) ORDER BY `created_at`,`id` DESC LIMIT 3") {
+		t.Fatalf("previous page sql %s", *sql)
+	}
+	if prev.Content[0].ID != 3 || prev.HasMore || prev.NextCursor == "" || prev.PrevCursor != "" {
+		t.Fatalf("previous page %+v", prev)
+	}
+}
+
+func TestCursorInvalid(t *testing.T) {
+	SetCursorSecret([]byte("secret"))
+	db := newTestDB(t, []article{{ID: 1}, {ID: 2}})
+	query := func() (*gorm.DB, error) { return db.Model(&article{}), nil }
+	page, err := CursorTemplate[article](CursorReq{Size: 1}, articleOrder, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte(page.NextCursor)
+	data[len(data)-2] ^= 1
+	cases := map[string]struct {
+		cursor string
+		order  []OrderColumn
+	}{
+		"tampered":      {string(data), articleOrder},
+		"not base64":    {"!!!", articleOrder},
+		"other columns": {page.NextCursor, []OrderColumn{{Column: "id"}}},
+	}
+	for name, c := range cases {
+		_, err := CursorTemplate[article](CursorReq{Cursor: c.cursor, Size: 1}, c.order, query)
+		var codeErr *response.CodeError
+		if !errors.As(err, &codeErr) || codeErr.Code.Code != CodeCursorInvalid.Code {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	SetCursorSecret([]byte("rotated"))
+	if _, err := CursorTemplate[article](CursorReq{Cursor: page.NextCursor, Size: 1}, articleOrder, query); err == nil {
+		t.Error("cursor signed with the old secret accepted")
+	}
+}
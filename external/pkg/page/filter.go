@@ -0,0 +1,327 @@
+package page
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bravpei/webtools/external/pkg/response"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// 过滤和排序参数错误码
+var (
+	CodeFilterInvalid = response.Register(response.ErrorCode{
+		Code: "FILTER_INVALID", Message: "invalid filter on %q: %s",
+		HTTPStatus: http.StatusBadRequest, Status: response.StatusValidationError,
+	})
+	CodeSortInvalid = response.Register(response.ErrorCode{
+		Code: "SORT_INVALID", Message: "field %q is not sortable",
+		HTTPStatus: http.StatusBadRequest, Status: response.StatusValidationError,
+	})
+)
+
+func init() {
+	response.RegisterCatalog("zh-CN", response.Catalog{Messages: map[string]string{
+		CodeFilterInvalid.Code: "字段 %q 的过滤条件无效：%s",
+		CodeSortInvalid.Code:   "字段 %q 不支持排序",
+	}})
+}
+
+// Operator 过滤运算符
+type Operator string
+
+const (
+	OpEq      Operator = "eq"
+	OpNe      Operator = "ne"
+	OpIn      Operator = "in"
+	OpLike    Operator = "like" // 包含匹配，值中的 % 和 _ 按字面处理
+	OpGt      Operator = "gt"
+	OpGte     Operator = "gte"
+	OpLt      Operator = "lt"
+	OpLte     Operator = "lte"
+	OpBetween Operator = "between" // 闭区间，值为两个元素的数组
+	OpNull    Operator = "null"
+	OpNotNull Operator = "notnull"
+)
+
+// Filter 过滤条件，Field 为模型字段的 json 名称（无 json 标签时为列名），
+// in 的值为数组，null 和 notnull 不需要值
+type Filter struct {
+	Field string   `json:"field"`
+	Op    Operator `json:"op"`
+	Value any      `json:"value,omitempty"`
+}
+
+// pageTag 模型字段上声明允许过滤和排序的标签，如 `page:"filter,sort"`
+const pageTag = "page"
+
+// allowedField 允许过滤或排序的字段
+type allowedField struct {
+	field  *schema.Field
+	filter bool
+	sort   bool
+}
+
+var allowedFieldCache sync.Map // map[*schema.Schema]map[string]allowedField
+
+// allowedFields 解析模型上的 page 标签，以 json 名称为键
+func allowedFields[T any](db *gorm.DB) (map[string]allowedField, error) {
+	sch, err := schema.Parse(new(T), &schemaCache, db.NamingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if fields, ok := allowedFieldCache.Load(sch); ok {
+		return fields.(map[string]allowedField), nil
+	}
+
+	fields := map[string]allowedField{}
+	for _, field := range sch.Fields {
+		tag, ok := field.Tag.Lookup(pageTag)
+		if !ok || field.DBName == "" {
+			continue
+		}
+		allowed := allowedField{field: field}
+		for _, opt := range strings.Split(tag, ",") {
+			switch strings.TrimSpace(opt) {
+			case "filter":
+				allowed.filter = true
+			case "sort":
+				allowed.sort = true
+			}
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			name = field.DBName
+		}
+		fields[name] = allowed
+	}
+	allowedFieldCache.Store(sch, fields)
+	return fields, nil
+}
+
+// applyFilters 校验并将 req 的过滤和排序条件加到查询上，列名只来自模型定义
+func applyFilters[T any](query *gorm.DB, req Req) (*gorm.DB, error) {
+	if len(req.Filters) == 0 && len(req.Sort) == 0 {
+		return query, nil
+	}
+	fields, err := allowedFields[T](query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range req.Filters {
+		allowed, ok := fields[f.Field]
+		if !ok || !allowed.filter {
+			return nil, CodeFilterInvalid.Err(f.Field, "field is not filterable")
+		}
+		expr, err := filterExpression(allowed.field, f)
+		if err != nil {
+			return nil, CodeFilterInvalid.Err(f.Field, err.Error())
+		}
+		query = query.Where(expr)
+	}
+	for _, s := range req.Sort {
+		name, desc := strings.CutPrefix(s, "-")
+		allowed, ok := fields[name]
+		if !ok || !allowed.sort {
+			return nil, CodeSortInvalid.Err(name)
+		}
+		query = query.Order(clause.OrderByColumn{Column: column(allowed.field), Desc: desc})
+	}
+	return query, nil
+}
+
+func column(field *schema.Field) clause.Column {
+	return clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+}
+
+func filterExpression(field *schema.Field, f Filter) (clause.Expression, error) {
+	col := column(field)
+	switch f.Op {
+	case OpNull:
+		return clause.Eq{Column: col, Value: nil}, nil
+	case OpNotNull:
+		return clause.Neq{Column: col, Value: nil}, nil
+	case OpIn, OpBetween:
+		values, err := convertValues(field, f.Value)
+		if err != nil {
+			return nil, err
+		}
+		if f.Op == OpIn {
+			if len(values) == 0 {
+				return nil, fmt.Errorf("in needs at least one value")
+			}
+			return clause.IN{Column: col, Values: values}, nil
+		}
+		if len(values) != 2 {
+			return nil, fmt.Errorf("between needs two values")
+		}
+		return clause.And(clause.Gte{Column: col, Value: values[0]}, clause.Lte{Column: col, Value: values[1]}), nil
+	case OpLike:
+		s, ok := f.Value.(string)
+		if !ok || field.IndirectFieldType.Kind() != reflect.String {
+			return nil, fmt.Errorf("like needs a string field and value")
+		}
+		// 显式声明转义符，不依赖数据库默认值；以参数传入，避免 MySQL 把字面量 '\' 当作转义
+		return clause.Expr{SQL: "? LIKE ? ESCAPE ?", Vars: []any{col, "%" + likeEscaper.Replace(s) + "%", `\`}}, nil
+	}
+
+	value, err := convertValue(field, f.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch f.Op {
+	case OpEq:
+		return clause.Eq{Column: col, Value: value}, nil
+	case OpNe:
+		return clause.Neq{Column: col, Value: value}, nil
+	case OpGt:
+		return clause.Gt{Column: col, Value: value}, nil
+	case OpGte:
+		return clause.Gte{Column: col, Value: value}, nil
+	case OpLt:
+		return clause.Lt{Column: col, Value: value}, nil
+	case OpLte:
+		return clause.Lte{Column: col, Value: value}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", f.Op)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// convertValues 将数组或逗号分隔的字符串转换为字段类型的值
+func convertValues(field *schema.Field, value any) ([]any, error) {
+	var raw []any
+	switch v := value.(type) {
+	case []any:
+		raw = v
+	case []string:
+		for _, s := range v {
+			raw = append(raw, s)
+		}
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			raw = append(raw, s)
+		}
+	default:
+		return nil, fmt.Errorf("value must be an array")
+	}
+	values := make([]any, len(raw))
+	for i, r := range raw {
+		v, err := convertValue(field, r)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// convertValue 将查询字符串或 JSON 中的值转换为字段类型，避免数据库按字符串比较
+func convertValue(field *schema.Field, value any) (any, error) {
+	if value == nil {
+		return nil, fmt.Errorf("value is required")
+	}
+	s, isString := value.(string)
+	switch field.IndirectFieldType {
+	case reflect.TypeOf(time.Time{}):
+		if !isString {
+			return nil, fmt.Errorf("time value must be a string")
+		}
+		for _, layout := range []string{time.RFC3339Nano, time.DateTime, time.DateOnly} {
+			if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid time %q", s)
+	}
+
+	switch kind := field.IndirectFieldType.Kind(); {
+	case kind == reflect.String:
+		if !isString {
+			return fmt.Sprint(value), nil
+		}
+		return s, nil
+	case kind == reflect.Bool:
+		if isString {
+			return strconv.ParseBool(s)
+		}
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case kind >= reflect.Int && kind <= reflect.Int64:
+		if isString {
+			return strconv.ParseInt(s, 10, 64)
+		}
+		if n, ok := value.(float64); ok && n == float64(int64(n)) {
+			return int64(n), nil
+		}
+	case kind >= reflect.Uint && kind <= reflect.Uint64:
+		if isString {
+			return strconv.ParseUint(s, 10, 64)
+		}
+		if n, ok := value.(float64); ok && n >= 0 && n == float64(uint64(n)) {
+			return uint64(n), nil
+		}
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		if isString {
+			return strconv.ParseFloat(s, 64)
+		}
+		if n, ok := value.(float64); ok {
+			return n, nil
+		}
+	default:
+		return nil, fmt.Errorf("field type %s can't be filtered", field.IndirectFieldType)
+	}
+	return nil, fmt.Errorf("invalid value %v", value)
+}
+
+// ParseQuery 从查询字符串解析分页、排序和过滤参数：
+//
+//	?page_num=1&page_size=20&count_mode=none&sort=-created_at,id
+//	&filter=status:eq:active&filter=age:between:18,30&filter=id:in:1,2,3&filter=deleted_at:null
+func ParseQuery(values url.Values) (req Req, err error) {
+	if req.PageNum, err = queryInt(values, "page_num", 1); err != nil {
+		return req, CodePageNumInvalid.Err()
+	}
+	if req.PageSize, err = queryInt(values, "page_size", 10); err != nil {
+		return req, CodePageSizeInvalid.Err(1, maxPageSize)
+	}
+	req.CountMode = CountMode(values.Get("count_mode"))
+
+	for _, sort := range values["sort"] {
+		for _, s := range strings.Split(sort, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				req.Sort = append(req.Sort, s)
+			}
+		}
+	}
+	for _, filter := range values["filter"] {
+		parts := strings.SplitN(filter, ":", 3)
+		if len(parts) < 2 {
+			return req, CodeFilterInvalid.Err(parts[0], "expected field:op:value")
+		}
+		f := Filter{Field: parts[0], Op: Operator(parts[1])}
+		if len(parts) == 3 {
+			f.Value = parts[2]
+		}
+		req.Filters = append(req.Filters, f)
+	}
+	return req, nil
+}
+
+func queryInt(values url.Values, key string, def int) (int, error) {
+	s := values.Get(key)
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
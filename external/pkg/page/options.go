@@ -0,0 +1,24 @@
+package page
+
+// Option 分页查询选项
+type Option func(*options)
+
+type options struct {
+	concurrent bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithConcurrent 并发执行统计和数据查询，适用于两者都较慢的大分页查询。
+// 两个查询使用各自的会话和连接，CountNone 模式下无效；事务中的查询仍顺序执行。
+func WithConcurrent() Option {
+	return func(o *options) {
+		o.concurrent = true
+	}
+}
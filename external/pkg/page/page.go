@@ -1,56 +1,125 @@
 package page
 
 import (
-	"errors"
+	"github.com/bravpei/webtools/external/pkg/response"
 	"gorm.io/gorm"
+	"net/http"
 )
 
-// Template 优化分页查询模板函数
-func Template[T any](req Req, handler func() (*gorm.DB, error)) (page Page[T], err error) {
+// 分页参数错误码，zh-CN 文案通过 response.RegisterCatalog 注册
+var (
+	CodePageNumInvalid = response.Register(response.ErrorCode{
+		Code: "PAGE_NUM_INVALID", Message: "page number must be greater than 0",
+		HTTPStatus: http.StatusBadRequest, Status: response.StatusValidationError,
+	})
+	CodePageSizeInvalid = response.Register(response.ErrorCode{
+		Code: "PAGE_SIZE_INVALID", Message: "page size must be between %d and %d",
+		HTTPStatus: http.StatusBadRequest, Status: response.StatusValidationError,
+	})
+	CodeCountModeInvalid = response.Register(response.ErrorCode{
+		Code: "COUNT_MODE_INVALID", Message: "unknown count mode %q",
+		HTTPStatus: http.StatusBadRequest, Status: response.StatusValidationError,
+	})
+)
+
+const maxPageSize = 100000
+
+func init() {
+	response.RegisterCatalog("zh-CN", response.Catalog{Messages: map[string]string{
+		CodePageNumInvalid.Code:   "页码必须大于0",
+		CodePageSizeInvalid.Code:  "每页条数必须在%d-%d之间",
+		CodeCountModeInvalid.Code: "不支持的总数统计方式 %q",
+	}})
+}
+
+// Template 优化分页查询模板函数，总数统计方式由 req.CountMode 决定，
+// req.Filters 和 req.Sort 在分页前自动应用到 handler 返回的查询上。
+// 总数确定请求页为空时不执行数据查询。
+func Template[T any](req Req, handler func() (*gorm.DB, error), opts ...Option) (page Page[T], err error) {
 	if err = req.validate(); err != nil {
 		return
 	}
+	o := newOptions(opts)
 
-	var total int64
-	results := make([]T, 0, req.PageSize) // 预分配容量，减少扩容开销
-
+	mode := req.CountMode
+	if mode == "" {
+		mode = CountExact
+	}
 	query, err := handler()
 	if err != nil {
 		return
 	}
-
-	countQuery := query.Session(&gorm.Session{})
-	newQuery := query.Session(&gorm.Session{NewDB: true}).Limit(-1).Offset(-1)
-	// 将克隆后的查询作为子查询，统计总数
-	if err = newQuery.Table("(?) as countQuery", countQuery).Count(&total).Error; err != nil {
+	if query, err = applyFilters[T](query, req); err != nil {
 		return
 	}
-	// 应用分页参数到原查询，获取结果
-	if total > 0 {
-		if err = query.Limit(req.PageSize).Offset((req.PageNum - 1) * req.PageSize).Find(&results).Error; err != nil {
-			return
+
+	offset := (req.PageNum - 1) * req.PageSize
+	results := make([]T, 0, req.PageSize+1) // 预分配容量，减少扩容开销
+	// 多取一条判断是否有下一页
+	fetch := func(q *gorm.DB) error {
+		return q.Limit(req.PageSize + 1).Offset(offset).Find(&results).Error
+	}
+	beyond := func(total int64) bool {
+		return int64(offset) >= total
+	}
+
+	var total int64
+	precise := false
+	count := counter[T](mode)
+	switch {
+	case count == nil:
+		err = fetch(query)
+	case o.concurrent && !inTransaction(query):
+		total, precise, err = countAndFetch(query, count, fetch, beyond)
+	default:
+		if total, precise, err = count(query); err == nil && !(precise && beyond(total)) {
+			err = fetch(query)
 		}
 	}
+	if err != nil {
+		return
+	}
+	if precise && beyond(total) {
+		results = results[:0]
+	}
+	hasNext := len(results) > req.PageSize
+	if hasNext {
+		results = results[:req.PageSize]
+	}
+
 	page = Page[T]{
 		Content:     results,
 		CurrentSize: len(results),
-		TotalSize:   total,
-		TotalPages:  (total + int64(req.PageSize) - 1) / int64(req.PageSize), // 新增总页数
+		HasNext:     hasNext,
+		CountMode:   mode,
+		Approximate: mode == CountApproximate && !precise,
+	}
+	if mode != CountNone {
+		page.TotalSize = total
+		page.TotalPages = (total + int64(req.PageSize) - 1) / int64(req.PageSize) // 新增总页数
 	}
 	return
 }
 
 type Req struct {
-	PageNum  int `json:"page_num" validate:"required|min:1"`
-	PageSize int `json:"page_size" validate:"required|min:1|max:100000"`
+	PageNum   int       `json:"page_num" validate:"required|min:1"`
+	PageSize  int       `json:"page_size" validate:"required|min:1|max:100000"`
+	CountMode CountMode `json:"count_mode" validate:"in:exact,none,cached,approximate"`
+	// Sort 排序字段，"-" 前缀表示降序，只允许模型上标记 `page:"sort"` 的字段
+	Sort []string `json:"sort,omitempty"`
+	// Filters 过滤条件，只允许模型上标记 `page:"filter"` 的字段
+	Filters []Filter `json:"filters,omitempty"`
 }
 
 func (r Req) validate() error {
 	if r.PageNum < 1 {
-		return errors.New("页码必须大于0")
+		return CodePageNumInvalid.Err()
+	}
+	if r.PageSize < 1 || r.PageSize > maxPageSize {
+		return CodePageSizeInvalid.Err(1, maxPageSize)
 	}
-	if r.PageSize < 1 || r.PageSize > 100000 {
-		return errors.New("每页条数必须在1-100000之间")
+	if !r.CountMode.valid() {
+		return CodeCountModeInvalid.Err(r.CountMode)
 	}
 	return nil
 }
@@ -60,6 +129,12 @@ type Page[T any] struct {
 	CurrentSize int   `json:"current_size"`
 	TotalSize   int64 `json:"total_size"`
 	TotalPages  int64 `json:"total_pages"` // 新增总页数字段
+	// HasNext 是否有下一页，各统计方式下均准确
+	HasNext bool `json:"has_next"`
+	// CountMode 实际使用的统计方式，CountNone 时 TotalSize 和 TotalPages 为 0
+	CountMode CountMode `json:"count_mode"`
+	// Approximate 为 true 表示 TotalSize 为估算值
+	Approximate bool `json:"approximate,omitempty"`
 }
 
 func GetPageReq(number, size int) Req {
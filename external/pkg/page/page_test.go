@@ -0,0 +1,295 @@
+package page
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bravpei/webtools/external/pkg/response"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
+)
+
+// testDB 不连接数据库的 gorm.DB，记录最后一条查询 SQL，按 LIMIT/OFFSET 返回 rows
+type testDB struct {
+	*gorm.DB
+	mu       sync.Mutex
+	sql      string
+	counts   int
+	fetches  int
+	countErr error
+}
+
+func newTestDB(t *testing.T, rows []article) *testDB {
+	t.Helper()
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tdb := &testDB{DB: db}
+	err = db.Callback().Query().Replace("gorm:query", func(db *gorm.DB) {
+		// 构建子查询时会重入此回调，只在更新状态时加锁
+		callbacks.BuildQuerySQL(db)
+		tdb.mu.Lock()
+		defer tdb.mu.Unlock()
+		tdb.sql = db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...)
+		switch dest := db.Statement.Dest.(type) {
+		case *int64:
+			tdb.counts++
+			if tdb.countErr != nil {
+				_ = db.AddError(tdb.countErr)
+				return
+			}
+			*dest = int64(len(rows))
+			db.RowsAffected = 1
+		case *[]article:
+			tdb.fetches++
+			result := rows
+			if c, ok := db.Statement.Clauses["LIMIT"].Expression.(clause.Limit); ok {
+				result = result[min(c.Offset, len(result)):]
+				if c.Limit != nil && *c.Limit >= 0 {
+					result = result[:min(*c.Limit, len(result))]
+				}
+			}
+			*dest = append(*dest, result...)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tdb
+}
+
+func articles(n int) []article {
+	rows := make([]article, n)
+	for i := range rows {
+		rows[i] = article{ID: int64(i + 1), CreatedAt: time.Now()}
+	}
+	return rows
+}
+
+// resetCountCache 清空总数缓存并恢复默认配置
+func resetCountCache() {
+	countCacheMu.Lock()
+	defer countCacheMu.Unlock()
+	clear(countCache)
+	countCacheList.Init()
+	countCacheTTL = time.Minute
+	countCacheSize = defaultCountCacheSize
+}
+
+func TestCountCacheBound(t *testing.T) {
+	resetCountCache()
+	defer resetCountCache()
+	db := newTestDB(t, articles(5))
+	filter := func(id int) func() (*gorm.DB, error) {
+		return func() (*gorm.DB, error) { return db.Model(&article{}).Where("id > ?", id), nil }
+	}
+	req := GetPageReq(1, 2)
+	req.CountMode = CountCached
+
+	// 超出上限时淘汰最早写入的条目
+	SetCountCacheSize(2)
+	for _, id := range []int{1, 2, 3, 3, 1} {
+		if _, err := Template[article](req, filter(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(countCache) != 2 || countCacheList.Len() != 2 || db.counts != 4 {
+		t.Fatalf("%d cached counts, %d counts", len(countCache), db.counts)
+	}
+
+	// 过期条目在之后的写入时清理
+	SetCountCacheTTL(50 * time.Millisecond)
+	for _, id := range []int{4, 5} {
+		if _, err := Template[article](req, filter(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := Template[article](req, filter(6)); err != nil {
+		t.Fatal(err)
+	}
+	if len(countCache) != 1 || countCacheList.Len() != 1 {
+		t.Fatalf("%d counts cached, expired ones kept", len(countCache))
+	}
+}
+
+func TestTemplateCountModes(t *testing.T) {
+	resetCountCache()
+
+	db := newTestDB(t, articles(5))
+	query := func() (*gorm.DB, error) { return db.Model(&article{}).Where("title <> ?", ""), nil }
+
+	exact, err := Template[article](GetPageReq(2, 2), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exact.TotalSize != 5 || exact.TotalPages != 3 || !exact.HasNext || exact.CountMode != CountExact || db.counts != 1 {
+		t.Fatalf("exact %+v, %d counts", exact, db.counts)
+	}
+
+	req := GetPageReq(3, 2)
+	req.CountMode = CountNone
+	none, err := Template[article](req, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if none.TotalSize != 0 || none.HasNext || none.CurrentSize != 1 || db.counts != 1 || !strings.Contains(db.sql, "LIMIT 3 OFFSET 4") {
+		t.Fatalf("none %+v, %d counts, sql %s", none, db.counts, db.sql)
+	}
+
+	// 相同条件复用总数，条件不同重新统计
+	req.CountMode = CountCached
+	for i := 0; i < 2; i++ {
+		cached, err := Template[article](req, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached.TotalSize != 5 || db.counts != 2 {
+			t.Fatalf("cached %+v, %d counts", cached, db.counts)
+		}
+	}
+	if _, err = Template[article](req, func() (*gorm.DB, error) { return db.Model(&article{}).Where("id > ?", 0), nil }); err != nil || db.counts != 3 {
+		t.Fatalf("cached with other filter: %v, %d counts", err, db.counts)
+	}
+
+	// 不支持估算的数据库退化为精确统计
+	req.CountMode = CountApproximate
+	approximate, err := Template[article](req, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approximate.Approximate || approximate.TotalSize != 5 || db.counts != 4 {
+		t.Fatalf("approximate %+v, %d counts", approximate, db.counts)
+	}
+
+	req.CountMode = "fast"
+	var codeErr *response.CodeError
+	if _, err = Template[article](req, query); !errors.As(err, &codeErr) || codeErr.Code.Code != CodeCountModeInvalid.Code {
+		t.Fatalf("invalid count mode: %v", err)
+	}
+}
+
+func TestTemplateFilters(t *testing.T) {
+	db := newTestDB(t, articles(1))
+	query := func() (*gorm.DB, error) { return db.Model(&article{}), nil }
+
+	values, _ := url.ParseQuery("page_num=1&page_size=10&sort=-created,title" +
+		"&filter=title:like:50%25_off&filter=id:in:1,2&filter=created:between:2024-01-01,2024-02-01&filter=title:notnull")
+	req, err := ParseQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Template[article](req, query); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"`articles`.`title` LIKE \"%50\\%\\_off%\" ESCAPE \"\\\"",
+		"`articles`.`id` IN (1,2)",
+		"(`articles`.`created_at` >= \"2024-01-01 00:00:00",
+		"`articles`.`title` IS NOT NULL",
+		"ORDER BY `articles`.`created_at` DESC,`articles`.`title` LIMIT 11",
+	} {
+		if !strings.Contains(db.sql, want) {
+			t.Errorf("sql %s\nmissing %s", db.sql, want)
+		}
+	}
+
+	// JSON 形式的过滤条件
+	req = GetPageReq(1, 10)
+	req.Filters = []Filter{{Field: "id", Op: OpGte, Value: float64(3)}, {Field: "title", Op: OpEq, Value: "go"}}
+	if _, err = Template[article](req, query); err != nil || !strings.Contains(db.sql, "`articles`.`id` >= 3 AND `articles`.`title` = \"go\"") {
+		t.Fatalf("json filters: %v, sql %s", err, db.sql)
+	}
+
+	cases := map[string]struct {
+		req  Req
+		code response.ErrorCode
+	}{
+		"unsortable":     {Req{Sort: []string{"id"}}, CodeSortInvalid},
+		"unknown column": {Req{Filters: []Filter{{Field: "id;drop table articles", Op: OpEq, Value: "1"}}}, CodeFilterInvalid},
+		"bad value":      {Req{Filters: []Filter{{Field: "id", Op: OpEq, Value: "abc"}}}, CodeFilterInvalid},
+		"bad operator":   {Req{Filters: []Filter{{Field: "id", Op: "regexp", Value: "1"}}}, CodeFilterInvalid},
+		"like on int":    {Req{Filters: []Filter{{Field: "id", Op: OpLike, Value: "1"}}}, CodeFilterInvalid},
+	}
+	for name, c := range cases {
+		c.req.PageNum, c.req.PageSize = 1, 10
+		_, err := Template[article](c.req, query)
+		var codeErr *response.CodeError
+		if !errors.As(err, &codeErr) || codeErr.Code.Code != c.code.Code {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
+
+func TestTemplateConcurrent(t *testing.T) {
+	db := newTestDB(t, articles(5))
+	query := func() (*gorm.DB, error) { return db.Model(&article{}), nil }
+
+	page, err := Template[article](GetPageReq(2, 2), query, WithConcurrent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.TotalSize != 5 || page.TotalPages != 3 || page.CurrentSize != 2 || page.Content[0].ID != 3 || !page.HasNext {
+		t.Fatalf("concurrent %+v", page)
+	}
+
+	// 请求页超出总数时不返回数据，总页数仍然正确
+	for _, opts := range [][]Option{nil, {WithConcurrent()}} {
+		fetches := db.fetches
+		page, err = Template[article](GetPageReq(4, 2), query, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.CurrentSize != 0 || page.HasNext || page.TotalSize != 5 || page.TotalPages != 3 {
+			t.Fatalf("beyond total %+v", page)
+		}
+		if opts == nil && db.fetches != fetches {
+			t.Fatal("data query ran for a page beyond the total")
+		}
+	}
+
+	db.countErr = errors.New("count failed")
+	if _, err = Template[article](GetPageReq(1, 2), query, WithConcurrent()); err == nil || err.Error() != "count failed" {
+		t.Fatalf("count error: %v", err)
+	}
+}
+
+// fakeTx 模拟事务连接，查询由 testDB 的回调处理，不会调用连接方法
+type fakeTx struct {
+	gorm.ConnPool
+}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func TestTemplateConcurrentInTransaction(t *testing.T) {
+	db := newTestDB(t, articles(5))
+	tx := db.WithContext(context.Background())
+	tx.Statement.ConnPool = fakeTx{}
+	query := func() (*gorm.DB, error) { return tx.Model(&article{}), nil }
+
+	page, err := Template[article](GetPageReq(2, 2), query, WithConcurrent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.TotalSize != 5 || page.CurrentSize != 2 || page.Content[0].ID != 3 {
+		t.Fatalf("in transaction %+v", page)
+	}
+
+	// 顺序执行时总数确定请求页为空，不执行数据查询
+	fetches := db.fetches
+	if page, err = Template[article](GetPageReq(4, 2), query, WithConcurrent()); err != nil || page.CurrentSize != 0 {
+		t.Fatalf("beyond total %+v %v", page, err)
+	}
+	if db.fetches != fetches {
+		t.Fatal("data query ran concurrently in a transaction")
+	}
+}
@@ -0,0 +1,294 @@
+package request
+
+import (
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+)
+
+// Binding sources, in the order they are applied. Later sources overwrite earlier ones.
+const (
+	sourceQuery  = "query"
+	sourceForm   = "form"
+	sourceHeader = "header"
+	sourcePath   = "path"
+)
+
+var bindSources = []string{sourceQuery, sourceForm, sourceHeader, sourcePath}
+
+var (
+	fileHeaderType      = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeaderSliceType = reflect.TypeOf([]*multipart.FileHeader(nil))
+	durationType        = reflect.TypeOf(time.Duration(0))
+)
+
+// bindField describes a struct field bound from a request source.
+type bindField struct {
+	index  []int
+	source string
+	name   string
+}
+
+// bindFieldCache caches the bound fields of each parameter type.
+var bindFieldCache sync.Map // map[reflect.Type][]bindField
+
+// readParams reads the request body and then binds query, form, header and path values
+// into params according to their struct tags. Bodies of a media type with a registered
+// Codec are decoded by it; allowed restricts the accepted media types when not empty.
+func readParams(ctx iris.Context, params any, allowed []string) error {
+	if hasBody(ctx.Request()) && ctx.Method() != http.MethodGet {
+		if mediaType := requestMediaType(ctx); !consumes(allowed, mediaType) {
+			return fmt.Errorf("%w: %q", errUnsupportedMediaType, mediaType)
+		}
+	}
+	if shouldReadBody(ctx) {
+		if err := readBody(ctx, params); err != nil {
+			return err
+		}
+	}
+	return bindParams(ctx, params)
+}
+
+func readBody(ctx iris.Context, params any) error {
+	if ctx.Method() == http.MethodGet {
+		return ctx.ReadBody(params)
+	}
+	if codec, ok := lookupCodec(requestMediaType(ctx)); ok {
+		return codec.Decode(ctx.Request().Body, params)
+	}
+	return ctx.ReadBody(params)
+}
+
+// shouldReadBody reports whether the body should be decoded with ctx.ReadBody.
+// GET requests keep iris' query/form binding; requests without a body and
+// multipart uploads are bound field by field instead.
+func shouldReadBody(ctx iris.Context) bool {
+	r := ctx.Request()
+	if r.Method == http.MethodGet {
+		return true
+	}
+	if !hasBody(r) {
+		return false
+	}
+	return ctx.GetContentTypeRequested() != context.ContentFormMultipartHeaderValue
+}
+
+func hasBody(r *http.Request) bool {
+	return r.ContentLength != 0 || len(r.TransferEncoding) > 0
+}
+
+// bindParams fills the tagged fields of params, which must be a pointer to a struct.
+func bindParams(ctx iris.Context, params any) error {
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	fields := cachedBindFields(v.Elem().Type())
+	if len(fields) == 0 {
+		return nil
+	}
+
+	r := ctx.Request()
+	var form map[string][]string
+	var files map[string][]*multipart.FileHeader
+	for _, source := range bindSources {
+		for _, field := range fields {
+			if field.source != source {
+				continue
+			}
+			target := fieldByIndex(v.Elem(), field.index)
+
+			var values []string
+			switch source {
+			case sourceQuery:
+				values = r.URL.Query()[field.name]
+			case sourceHeader:
+				values = r.Header.Values(field.name)
+			case sourcePath:
+				if entry, ok := ctx.Params().Store.GetEntry(field.name); ok {
+					values = []string{entry.String()}
+				}
+			case sourceForm:
+				if form == nil {
+					form, files = readForm(ctx)
+				}
+				if target.Type() == fileHeaderType || target.Type() == fileHeaderSliceType {
+					if err := setFiles(target, files[field.name]); err != nil {
+						return fmt.Errorf("bind form field %q: %v", field.name, err)
+					}
+					continue
+				}
+				values = form[field.name]
+			}
+
+			if len(values) == 0 {
+				continue
+			}
+			if err := setValues(target, values); err != nil {
+				return fmt.Errorf("bind %s parameter %q: %v", source, field.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// readForm parses url-encoded and multipart forms, returning values and uploaded files.
+func readForm(ctx iris.Context) (map[string][]string, map[string][]*multipart.FileHeader) {
+	r := ctx.Request()
+	if ctx.GetContentTypeRequested() == context.ContentFormMultipartHeaderValue && r.MultipartForm == nil {
+		_ = r.ParseMultipartForm(ctx.Application().ConfigurationReadOnly().GetPostMaxMemory())
+	}
+	form := ctx.FormValues()
+	if form == nil {
+		form = map[string][]string{}
+	}
+	var files map[string][]*multipart.FileHeader
+	if r.MultipartForm != nil {
+		files = r.MultipartForm.File
+	}
+	return form, files
+}
+
+func cachedBindFields(t reflect.Type) []bindField {
+	if cached, ok := bindFieldCache.Load(t); ok {
+		return cached.([]bindField)
+	}
+	fields := collectBindFields(t, nil)
+	bindFieldCache.Store(t, fields)
+	return fields
+}
+
+// collectBindFields walks exported fields, descending into embedded structs.
+func collectBindFields(t reflect.Type, parent []int) []bindField {
+	var fields []bindField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+		if f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, collectBindFields(ft, index)...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		for _, source := range bindSources {
+			name, _, _ := strings.Cut(f.Tag.Get(source), ",")
+			if name != "" && name != "-" {
+				fields = append(fields, bindField{index: index, source: source, name: name})
+			}
+		}
+	}
+	return fields
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex but allocates nil embedded pointers.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func setFiles(target reflect.Value, files []*multipart.FileHeader) error {
+	if len(files) == 0 {
+		return nil
+	}
+	switch target.Type() {
+	case fileHeaderType:
+		target.Set(reflect.ValueOf(files[0]))
+	case fileHeaderSliceType:
+		target.Set(reflect.ValueOf(files))
+	default:
+		return fmt.Errorf("unsupported file field type %s", target.Type())
+	}
+	return nil
+}
+
+// setValues assigns string values to a scalar, pointer or slice field.
+func setValues(target reflect.Value, values []string) error {
+	switch target.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(target.Type().Elem())
+		if err := setValues(elem.Elem(), values); err != nil {
+			return err
+		}
+		target.Set(elem)
+		return nil
+	case reflect.Slice:
+		slice := reflect.MakeSlice(target.Type(), 0, len(values))
+		for _, value := range values {
+			elem := reflect.New(target.Type().Elem()).Elem()
+			if err := setValue(elem, value); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, elem)
+		}
+		target.Set(slice)
+		return nil
+	default:
+		return setValue(target, values[0])
+	}
+}
+
+func setValue(target reflect.Value, value string) error {
+	if target.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		target.SetInt(int64(d))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		target.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", target.Type())
+	}
+	return nil
+}
@@ -0,0 +1,504 @@
+package request
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bravpei/webtools/external/pkg/response"
+	"github.com/kataras/iris/v12"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Media types of the built-in codecs
+const (
+	MediaTypeJSON     = "application/json"
+	MediaTypeXML      = "application/xml"
+	MediaTypeMsgPack  = "application/msgpack"
+	MediaTypeProtobuf = "application/x-protobuf"
+)
+
+// Codec encodes response results and decodes request bodies of one media type.
+type Codec interface {
+	MediaType() string
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+	// codecOrder lists registered media types, offered to routes without WithProduces
+	codecOrder []string
+	// optInMediaTypes are only offered to routes listing them in WithProduces.
+	// XML can't encode map data, so results built from maps would fail to encode.
+	optInMediaTypes = map[string]bool{MediaTypeXML: true, "text/xml": true}
+)
+
+func init() {
+	RegisterCodec(jsonCodec{}, "text/json")
+	RegisterCodec(xmlCodec{}, "text/xml")
+	RegisterCodec(msgpackCodec{}, "application/x-msgpack", "application/vnd.msgpack")
+	RegisterCodec(protobufCodec{}, "application/protobuf", "application/vnd.google.protobuf")
+}
+
+// RegisterCodec registers a codec for its media type and the aliases, replacing any codec
+// registered for them before.
+func RegisterCodec(codec Codec, aliases ...string) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for _, mediaType := range append([]string{codec.MediaType()}, aliases...) {
+		mediaType = strings.ToLower(mediaType)
+		if _, ok := codecs[mediaType]; !ok {
+			codecOrder = append(codecOrder, mediaType)
+		}
+		codecs[mediaType] = codec
+	}
+}
+
+// lookupCodec returns the codec registered for a media type.
+func lookupCodec(mediaType string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[strings.ToLower(mediaType)]
+	return codec, ok
+}
+
+const codecKey = "request.codec"
+
+// negotiateCodec selects the response codec from the Accept header among the produces
+// media types, or the registered ones except XML when produces is empty. JSON is
+// preferred whenever a wildcard range such as */* or application/* matches it, so
+// browsers and other clients accepting anything get JSON. Media types excluded with
+// q=0 are never selected through a less specific range.
+func negotiateCodec(ctx iris.Context, produces []string) (Codec, bool) {
+	if len(produces) == 0 {
+		codecsMu.RLock()
+		produces = []string{MediaTypeJSON}
+		for _, mediaType := range codecOrder {
+			if !optInMediaTypes[mediaType] {
+				produces = append(produces, mediaType)
+			}
+		}
+		codecsMu.RUnlock()
+	}
+	accept := ctx.GetHeader("Accept")
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+
+	ranges, excluded := parseAccept(accept)
+	for _, mediaRange := range ranges {
+		if strings.HasSuffix(mediaRange, "/*") && matchMediaRange(mediaRange, MediaTypeJSON) && producesJSON(produces) &&
+			!isExcluded(excluded, mediaRange, MediaTypeJSON) {
+			if codec, ok := lookupCodec(MediaTypeJSON); ok {
+				return codec, true
+			}
+		}
+		for _, mediaType := range produces {
+			if !matchMediaRange(mediaRange, mediaType) || isExcluded(excluded, mediaRange, mediaType) {
+				continue
+			}
+			// Aliases such as text/json are written with the codec's media type, which must not be excluded either
+			if codec, ok := lookupCodec(mediaType); ok && !isExcluded(excluded, mediaRange, codec.MediaType()) {
+				return codec, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func producesJSON(produces []string) bool {
+	for _, mediaType := range produces {
+		if strings.EqualFold(mediaType, MediaTypeJSON) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAccept returns the media ranges of an Accept header by descending quality, and
+// the ranges excluded with q=0.
+func parseAccept(accept string) (ranges []string, excluded []string) {
+	type weighted struct {
+		mediaRange string
+		q          float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if mediaRange == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+		if q > 0 {
+			accepted = append(accepted, weighted{mediaRange, q})
+		} else {
+			excluded = append(excluded, mediaRange)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+
+	ranges = make([]string, len(accepted))
+	for i, r := range accepted {
+		ranges[i] = r.mediaRange
+	}
+	return ranges, excluded
+}
+
+// isExcluded reports whether a q=0 range more specific than mediaRange matches mediaType,
+// e.g. application/json;q=0 wins over */* for JSON.
+func isExcluded(excluded []string, mediaRange, mediaType string) bool {
+	for _, e := range excluded {
+		if matchMediaRange(e, mediaType) && specificity(e) > specificity(mediaRange) {
+			return true
+		}
+	}
+	return false
+}
+
+// specificity ranks */* below type/* below a full media type.
+func specificity(mediaRange string) int {
+	switch {
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func matchMediaRange(mediaRange, mediaType string) bool {
+	mediaType = strings.ToLower(mediaType)
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(mediaRange, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+// responseCodec returns the codec negotiated for the request, JSON by default.
+func responseCodec(ctx iris.Context) Codec {
+	if codec, ok := ctx.Values().Get(codecKey).(Codec); ok {
+		return codec
+	}
+	return jsonCodec{}
+}
+
+// errUnsupportedMediaType is returned by readParams when the route doesn't consume the body's media type.
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// requestMediaType returns the media type of the request body without parameters.
+func requestMediaType(ctx iris.Context) string {
+	mediaType, _, err := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// consumes reports whether the route accepts a body of the media type.
+func consumes(allowed []string, mediaType string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, m := range allowed {
+		if strings.EqualFold(m, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) MediaType() string { return MediaTypeJSON }
+
+func (jsonCodec) Encode(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (jsonCodec) Decode(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) MediaType() string { return MediaTypeXML }
+
+func (xmlCodec) Encode(w io.Writer, v any) error {
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func (xmlCodec) Decode(r io.Reader, v any) error {
+	return xml.NewDecoder(r).Decode(v)
+}
+
+// msgpackCodec uses json tags so field names match the JSON encoding.
+type msgpackCodec struct{}
+
+func (msgpackCodec) MediaType() string { return MediaTypeMsgPack }
+
+func (msgpackCodec) Encode(w io.Writer, v any) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	return enc.Encode(v)
+}
+
+func (msgpackCodec) Decode(r io.Reader, v any) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// protobufCodec marshals proto messages directly. A response.Result is sent as the
+// envelope below, with proto message data packed as is and other data as a
+// google.protobuf.Value holding its JSON form. Decoding into a *response.Result leaves
+// the packed data as a *anypb.Any.
+//
+//	message Result {
+//	  int32 status = 1;
+//	  string err_code = 2;
+//	  string message = 3;
+//	  google.protobuf.Any data = 4;
+//	  string trace_id = 5;
+//	  repeated FieldError errors = 6; // string field = 1; string rule = 2; string message = 3;
+//	  string details = 7;
+//	}
+//
+// Params holding a message must be a pointer to it, Handle rejects message values. Other
+// values are sent and read as a google.protobuf.Value holding their JSON form.
+type protobufCodec struct{}
+
+func (protobufCodec) MediaType() string { return MediaTypeProtobuf }
+
+func (protobufCodec) Encode(w io.Writer, v any) error {
+	var b []byte
+	var err error
+	switch v := v.(type) {
+	case response.Result:
+		b, err = marshalResult(v)
+	case proto.Message:
+		b, err = proto.Marshal(v)
+	default:
+		var value *structpb.Value
+		if value, err = jsonValue(v); err == nil {
+			b, err = proto.Marshal(value)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (protobufCodec) Decode(r io.Reader, v any) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if result, ok := v.(*response.Result); ok {
+		return unmarshalResult(b, result)
+	}
+	if msg, ok := protoTarget(v); ok {
+		return proto.Unmarshal(b, msg)
+	}
+	value := &structpb.Value{}
+	if err := proto.Unmarshal(b, value); err != nil {
+		return err
+	}
+	if b, err = protojson.Marshal(value); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// protoTarget returns the message v decodes into: v itself, or the message *v points
+// to, allocated when nil, for Params declared as a message pointer.
+func protoTarget(v any) (proto.Message, bool) {
+	if msg, ok := v.(proto.Message); ok {
+		return msg, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Pointer || !rv.Elem().Type().Implements(protoMessageType) {
+		return nil, false
+	}
+	if rv.Elem().IsNil() {
+		rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+	}
+	return rv.Elem().Interface().(proto.Message), true
+}
+
+// jsonValue converts v to a google.protobuf.Value through its JSON form.
+func jsonValue(v any) (*structpb.Value, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	value := &structpb.Value{}
+	return value, protojson.Unmarshal(b, value)
+}
+
+// Field numbers of the protobuf Result envelope
+const (
+	resultStatus  protowire.Number = 1
+	resultErrCode protowire.Number = 2
+	resultMessage protowire.Number = 3
+	resultData    protowire.Number = 4
+	resultTraceId protowire.Number = 5
+	resultErrors  protowire.Number = 6
+	resultDetails protowire.Number = 7
+
+	fieldErrorField   protowire.Number = 1
+	fieldErrorRule    protowire.Number = 2
+	fieldErrorMessage protowire.Number = 3
+)
+
+func marshalResult(result response.Result) ([]byte, error) {
+	var b []byte
+	if result.Status != 0 {
+		b = protowire.AppendTag(b, resultStatus, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(result.Status))
+	}
+	b = appendString(b, resultErrCode, result.ErrCode)
+	b = appendString(b, resultMessage, result.Message)
+	if result.Data != nil {
+		msg, ok := result.Data.(proto.Message)
+		if !ok {
+			value, err := jsonValue(result.Data)
+			if err != nil {
+				return nil, err
+			}
+			msg = value
+		}
+		data, err := anypb.New(msg)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := proto.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, resultData, protowire.BytesType)
+		b = protowire.AppendBytes(b, raw)
+	}
+	b = appendString(b, resultTraceId, result.TraceId)
+	for _, fieldErr := range result.Errors {
+		var raw []byte
+		raw = appendString(raw, fieldErrorField, fieldErr.Field)
+		raw = appendString(raw, fieldErrorRule, fieldErr.Rule)
+		raw = appendString(raw, fieldErrorMessage, fieldErr.Message)
+		b = protowire.AppendTag(b, resultErrors, protowire.BytesType)
+		b = protowire.AppendBytes(b, raw)
+	}
+	b = appendString(b, resultDetails, result.Details)
+	return b, nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func unmarshalResult(b []byte, result *response.Result) error {
+	*result = response.Result{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		switch {
+		case num == resultStatus && typ == protowire.VarintType:
+			status, _ := protowire.ConsumeVarint(field)
+			result.Status = response.Status(status)
+		case typ != protowire.BytesType:
+		case num == resultErrCode:
+			result.ErrCode = string(field)
+		case num == resultMessage:
+			result.Message = string(field)
+		case num == resultData:
+			data := &anypb.Any{}
+			if err := proto.Unmarshal(field, data); err != nil {
+				return err
+			}
+			result.Data = data
+		case num == resultTraceId:
+			result.TraceId = string(field)
+		case num == resultErrors:
+			var fieldErr response.FieldError
+			err := consumeFields(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+				switch {
+				case typ != protowire.BytesType:
+				case num == fieldErrorField:
+					fieldErr.Field = string(field)
+				case num == fieldErrorRule:
+					fieldErr.Rule = string(field)
+				case num == fieldErrorMessage:
+					fieldErr.Message = string(field)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			result.Errors = append(result.Errors, fieldErr)
+		case num == resultDetails:
+			result.Details = string(field)
+		}
+		return nil
+	})
+}
+
+// consumeFields calls f with each field of a message. Varint fields are passed in their
+// encoded form and length-delimited ones without the length; unknown fields are skipped.
+func consumeFields(b []byte, f func(num protowire.Number, typ protowire.Type, field []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		field := b[:n]
+		if typ == protowire.BytesType {
+			field, _ = protowire.ConsumeBytes(field)
+		}
+		if err := f(num, typ, field); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
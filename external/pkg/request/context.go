@@ -0,0 +1,33 @@
+package request
+
+import (
+	"context"
+
+	"github.com/kataras/iris/v12"
+)
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx carrying the authenticated claims.
+func WithClaims(ctx context.Context, claims any) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// SetClaims stores claims in the request context, for use by authentication middleware:
+//
+//	app.Use(func(ctx iris.Context) {
+//		claims, err := jwtUtil.Parse(token)
+//		...
+//		request.SetClaims(ctx, claims)
+//		ctx.Next()
+//	})
+func SetClaims(ctx iris.Context, claims any) {
+	r := ctx.Request()
+	ctx.ResetRequest(r.WithContext(WithClaims(r.Context(), claims)))
+}
+
+// ClaimsFrom returns the claims stored by WithClaims or SetClaims when they have type T.
+func ClaimsFrom[T any](ctx context.Context) (T, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(T)
+	return claims, ok
+}
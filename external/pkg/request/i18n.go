@@ -0,0 +1,61 @@
+package request
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/bravpei/webtools/external/pkg/response"
+	"github.com/gookit/validate"
+	"github.com/gookit/validate/locales/zhcn"
+	"github.com/kataras/iris/v12"
+)
+
+const localeKey = "request.locale"
+
+func init() {
+	response.RegisterCatalog(zhcn.Name, response.Catalog{Validators: zhcn.Data})
+}
+
+// Locale returns the locale negotiated from the request's Accept-Language header.
+func Locale(ctx iris.Context) string {
+	if locale := ctx.Values().GetString(localeKey); locale != "" {
+		return locale
+	}
+	locale := response.Negotiate(ctx.GetHeader("Accept-Language"))
+	ctx.Values().Set(localeKey, locale)
+	return locale
+}
+
+// validateParams validates params with the locale's validator messages and field names.
+// It returns nil when params are valid or a nil pointer, such as message Params of a
+// request without a body, otherwise the failures ordered by field and rule.
+func validateParams(params any, locale string, all bool) []response.FieldError {
+	if v := reflect.ValueOf(params); v.Kind() == reflect.Pointer && v.IsNil() {
+		return nil
+	}
+	v := validate.Struct(params)
+	if messages := response.ValidatorMessages(locale); len(messages) > 0 {
+		v.AddMessages(messages)
+	}
+	if fields := response.FieldNames(locale); len(fields) > 0 {
+		v.AddTranslates(fields)
+	}
+	v.StopOnError = !all
+	if v.Validate() {
+		return nil
+	}
+
+	var errs []response.FieldError
+	for field, rules := range v.Errors {
+		for rule, message := range rules {
+			errs = append(errs, response.FieldError{Field: field, Rule: rule, Message: message})
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Field != errs[j].Field {
+			return errs[i].Field < errs[j].Field
+		}
+		return errs[i].Rule < errs[j].Rule
+	})
+	return errs
+}
@@ -0,0 +1,402 @@
+package request
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bravpei/webtools/external/pkg/response"
+	"github.com/kataras/iris/v12"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Idempotency headers
+const (
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotency-Replayed"
+)
+
+// StoredResult is the first response written for an idempotency key.
+type StoredResult struct {
+	HTTPStatus int             `json:"http_status"`
+	Result     response.Result `json:"result"`
+	// Fingerprint is the hash of the query and body of the request that produced the result
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Streamed marks a request answered by a Responder, whose output is not stored
+	Streamed bool `json:"streamed,omitempty"`
+}
+
+// MaxIdempotencyKeyLength is the longest Idempotency-Key header accepted; longer keys fail
+// with IDEMPOTENCY_KEY_INVALID (HTTP 400).
+const MaxIdempotencyKeyLength = 255
+
+// DefaultIdempotencyLockTTL is how long a running request holds its idempotency key unless
+// set with WithIdempotencyLockTTL. Keys held by a crashed instance can be retried after it.
+const DefaultIdempotencyLockTTL = time.Minute
+
+// IdempotencyStore keeps the state of idempotency keys.
+//
+// Acquire claims key for the lock ttl. It returns the stored result when the key has
+// completed, acquired=true when the caller now owns the key, and neither when another
+// request holding the key is still in flight. Save stores the owner's result for ttl and
+// Release gives up an owned key without a result so the client can retry.
+type IdempotencyStore interface {
+	Acquire(ctx context.Context, key string, ttl time.Duration) (stored *StoredResult, acquired bool, err error)
+	Save(ctx context.Context, key string, result StoredResult, ttl time.Duration) error
+	Release(ctx context.Context, key string) error
+}
+
+// WithIdempotency makes requests carrying an Idempotency-Key header run at most once per
+// caller, method, path and key within ttl. The caller is the scope set with
+// WithIdempotencyScope, or the claims stored by SetClaims. Replays get the first result
+// with an Idempotency-Replayed header; a replay whose query or body differs from the first
+// request fails with IDEMPOTENCY_KEY_REUSED (HTTP 422). Concurrent duplicates fail with
+// IDEMPOTENCY_IN_FLIGHT (HTTP 409) while the first request holds the key, see
+// WithIdempotencyLockTTL. Results with a 5xx status are not stored, so those requests can
+// be retried with the same key. Output written by a Responder is not stored either: the
+// key is marked completed and replays fail with IDEMPOTENCY_NOT_REPLAYABLE (HTTP 409)
+// instead of running the business function again. The body of such requests is buffered
+// to fingerprint it; bodies larger than iris' PostMaxMemory fail with REQUEST_TOO_LARGE
+// (HTTP 413).
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) TemplateOption {
+	return func(o *templateOptions) {
+		o.idempotencyStore = store
+		o.idempotencyTTL = ttl
+	}
+}
+
+// WithIdempotencyLockTTL sets how long a running request holds its idempotency key,
+// DefaultIdempotencyLockTTL by default. It is raised to the WithTimeout duration and
+// capped at the ttl of WithIdempotency.
+func WithIdempotencyLockTTL(ttl time.Duration) TemplateOption {
+	return func(o *templateOptions) {
+		o.idempotencyLock = ttl
+	}
+}
+
+// WithIdempotencyScope scopes idempotency keys by the returned value, e.g. the tenant or
+// user ID, instead of the claims stored by SetClaims.
+func WithIdempotencyScope(scope func(ctx iris.Context) string) TemplateOption {
+	return func(o *templateOptions) {
+		o.idempotencyScope = scope
+	}
+}
+
+// idempotencyCall is the key owned by the current request; nil when idempotency is off.
+type idempotencyCall struct {
+	store       IdempotencyStore
+	key         string
+	fingerprint string
+	ttl         time.Duration
+}
+
+// idempotencyFingerprint hashes the query and body of a request carrying an idempotency
+// key, buffering the body so it can still be bound. It returns "" when idempotency is off,
+// and an *http.MaxBytesError when the body is larger than iris' PostMaxMemory.
+func idempotencyFingerprint(ctx iris.Context, options *templateOptions) (string, error) {
+	if options.idempotencyStore == nil || ctx.GetHeader(HeaderIdempotencyKey) == "" {
+		return "", nil
+	}
+	r := ctx.Request()
+	hash := sha256.New()
+	hash.Write([]byte(r.URL.RawQuery))
+	hash.Write([]byte{0})
+	if r.Body != nil {
+		// The body is buffered whole, bounded like the in-memory part of form binding
+		limit := ctx.Application().ConfigurationReadOnly().GetPostMaxMemory()
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.ResponseWriter(), r.Body, limit))
+		if err != nil {
+			return "", err
+		}
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// idempotencyScope identifies the caller of a request, hashed to a fixed length.
+// Requests without a scope or claims share the same scope.
+func idempotencyScope(ctx iris.Context, options *templateOptions) string {
+	var scope []byte
+	if options.idempotencyScope != nil {
+		scope = []byte(options.idempotencyScope(ctx))
+	} else if claims := ctx.Request().Context().Value(claimsKey{}); claims != nil {
+		var err error
+		if scope, err = json.Marshal(claims); err != nil {
+			scope = []byte(fmt.Sprintf("%#v", claims))
+		}
+	}
+	if len(scope) == 0 {
+		return "-"
+	}
+	sum := sha256.Sum256(scope)
+	return hex.EncodeToString(sum[:16])
+}
+
+// idempotencyKey is the store key of a request, hashed so it fits IdempotencyRecord.Key
+// whatever the length of the path and header.
+func idempotencyKey(method, path, scope, header string) string {
+	sum := sha256.Sum256([]byte(method + " " + path + " " + scope + " " + header))
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyLockTTL is the lock ttl of WithIdempotencyLockTTL, at least the business
+// timeout and at most the result ttl.
+func (o *templateOptions) idempotencyLockTTL() time.Duration {
+	lock := o.idempotencyLock
+	if lock <= 0 {
+		lock = DefaultIdempotencyLockTTL
+	}
+	return min(max(lock, o.timeout), o.idempotencyTTL)
+}
+
+// acquireIdempotency claims the request's idempotency key. When it returns false the
+// response has already been written, either a replay or an error.
+func acquireIdempotency(ctx iris.Context, options *templateOptions, locale, fingerprint string) (*idempotencyCall, bool) {
+	header := ctx.GetHeader(HeaderIdempotencyKey)
+	if options.idempotencyStore == nil || header == "" {
+		return nil, true
+	}
+	if len(header) > MaxIdempotencyKeyLength {
+		writeError(ctx, response.CodeIdempotencyKeyInvalid, response.CodeIdempotencyKeyInvalid.Localize(locale))
+		return nil, false
+	}
+	reqCtx := ctx.Request().Context()
+	call := &idempotencyCall{
+		store:       options.idempotencyStore,
+		key:         idempotencyKey(ctx.Method(), ctx.Path(), idempotencyScope(ctx, options), header),
+		fingerprint: fingerprint,
+		ttl:         options.idempotencyTTL,
+	}
+
+	stored, acquired, err := call.store.Acquire(reqCtx, call.key, options.idempotencyLockTTL())
+	switch {
+	case err != nil:
+		slog.ErrorContext(reqCtx, fmt.Sprintf("Failed to acquire idempotency key: %v", err))
+		writeError(ctx, response.CodeInternal, response.CodeInternal.Localize(locale))
+		return nil, false
+	case stored != nil && stored.Fingerprint != "" && stored.Fingerprint != fingerprint:
+		writeError(ctx, response.CodeIdempotencyMismatch, response.CodeIdempotencyMismatch.Localize(locale))
+		return nil, false
+	case stored != nil && stored.Streamed:
+		writeError(ctx, response.CodeIdempotencyStreamed, response.CodeIdempotencyStreamed.Localize(locale))
+		return nil, false
+	case stored != nil:
+		ctx.Header(HeaderIdempotencyReplayed, "true")
+		writeResult(ctx, stored.HTTPStatus, stored.Result)
+		return nil, false
+	case !acquired:
+		writeError(ctx, response.CodeInFlight, response.CodeInFlight.Localize(locale))
+		return nil, false
+	}
+	return call, true
+}
+
+// complete stores the result, or releases the key for results that should be retried.
+func (c *idempotencyCall) complete(ctx context.Context, status int, result response.Result) {
+	if c == nil {
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		c.release(ctx)
+		return
+	}
+	// The result is stored even if the client has gone away
+	if err := c.store.Save(context.WithoutCancel(ctx), c.key, StoredResult{HTTPStatus: status, Result: result, Fingerprint: c.fingerprint}, c.ttl); err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("Failed to save idempotency result: %v", err))
+	}
+}
+
+// completeStreamed marks the key completed before a Responder writes the response,
+// so a retry doesn't repeat the side effects.
+func (c *idempotencyCall) completeStreamed(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	stored := StoredResult{HTTPStatus: http.StatusOK, Fingerprint: c.fingerprint, Streamed: true}
+	if err := c.store.Save(context.WithoutCancel(ctx), c.key, stored, c.ttl); err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("Failed to save idempotency result: %v", err))
+	}
+}
+
+func (c *idempotencyCall) release(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	if err := c.store.Release(context.WithoutCancel(ctx), c.key); err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("Failed to release idempotency key: %v", err))
+	}
+}
+
+// MemoryIdempotencyStore is an in-process IdempotencyStore that keeps at most
+// capacity keys, evicting the least recently used.
+type MemoryIdempotencyStore struct {
+	mutex    sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+type memoryIdempotencyEntry struct {
+	key     string
+	result  *StoredResult
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore creates a MemoryIdempotencyStore; capacity <= 0 means unbounded.
+func NewMemoryIdempotencyStore(capacity int) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{capacity: capacity, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (s *MemoryIdempotencyStore) Acquire(_ context.Context, key string, ttl time.Duration) (*StoredResult, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if elem, ok := s.entries[key]; ok {
+		entry := elem.Value.(*memoryIdempotencyEntry)
+		if now.Before(entry.expires) {
+			s.lru.MoveToFront(elem)
+			return entry.result, false, nil
+		}
+		s.remove(elem)
+	}
+
+	s.entries[key] = s.lru.PushFront(&memoryIdempotencyEntry{key: key, expires: now.Add(ttl)})
+	for s.capacity > 0 && s.lru.Len() > s.capacity {
+		s.remove(s.lru.Back())
+	}
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Save(_ context.Context, key string, result StoredResult, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := &memoryIdempotencyEntry{key: key, result: &result, expires: time.Now().Add(ttl)}
+	if elem, ok := s.entries[key]; ok {
+		elem.Value = entry
+		s.lru.MoveToFront(elem)
+		return nil
+	}
+	s.entries[key] = s.lru.PushFront(entry)
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if elem, ok := s.entries[key]; ok && elem.Value.(*memoryIdempotencyEntry).result == nil {
+		s.remove(elem)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.entries, elem.Value.(*memoryIdempotencyEntry).key)
+}
+
+// IdempotencyRecord is the table row of GormIdempotencyStore.
+type IdempotencyRecord struct {
+	Key       string `gorm:"primaryKey;size:255"`
+	Done      bool
+	Result    []byte
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+func (IdempotencyRecord) TableName() string {
+	return "idempotency_records"
+}
+
+// GormIdempotencyStore is an IdempotencyStore backed by a database table, shared by all instances.
+// Create the table with AutoMigrate.
+type GormIdempotencyStore struct {
+	db *gorm.DB
+}
+
+// NewGormIdempotencyStore creates a GormIdempotencyStore.
+func NewGormIdempotencyStore(db *gorm.DB) *GormIdempotencyStore {
+	return &GormIdempotencyStore{db: db}
+}
+
+// AutoMigrate creates or updates the idempotency_records table.
+func (s *GormIdempotencyStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&IdempotencyRecord{})
+}
+
+// DeleteExpired removes expired keys, meant to be run periodically.
+func (s *GormIdempotencyStore) DeleteExpired(ctx context.Context) error {
+	return s.db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&IdempotencyRecord{}).Error
+}
+
+func (s *GormIdempotencyStore) Acquire(ctx context.Context, key string, ttl time.Duration) (*StoredResult, bool, error) {
+	db := s.db.WithContext(ctx)
+	// The insert decides ownership; a second attempt covers a key whose previous record expired
+	for attempt := 0; attempt < 2; attempt++ {
+		now := time.Now()
+		insert := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&IdempotencyRecord{Key: key, ExpiresAt: now.Add(ttl)})
+		if insert.Error != nil {
+			return nil, false, insert.Error
+		}
+		if insert.RowsAffected == 1 {
+			return nil, true, nil
+		}
+
+		var record IdempotencyRecord
+		if err := db.Where(keyEquals(key)).Take(&record).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return nil, false, err
+		}
+		if now.Before(record.ExpiresAt) {
+			if !record.Done {
+				return nil, false, nil
+			}
+			var stored StoredResult
+			if err := json.Unmarshal(record.Result, &stored); err != nil {
+				return nil, false, err
+			}
+			return &stored, false, nil
+		}
+		if err := db.Where(keyEquals(key)).Where("expires_at = ?", record.ExpiresAt).Delete(&IdempotencyRecord{}).Error; err != nil {
+			return nil, false, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *GormIdempotencyStore) Save(ctx context.Context, key string, result StoredResult, ttl time.Duration) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"done", "result", "expires_at"}),
+	}).Create(&IdempotencyRecord{Key: key, Done: true, Result: data, ExpiresAt: time.Now().Add(ttl)}).Error
+}
+
+func (s *GormIdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where(keyEquals(key)).Where("done = ?", false).Delete(&IdempotencyRecord{}).Error
+}
+
+// keyEquals matches the key column, quoted for the dialect since key is reserved in MySQL.
+func keyEquals(key string) clause.Eq {
+	return clause.Eq{Column: clause.Column{Name: "key"}, Value: key}
+}
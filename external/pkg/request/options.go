@@ -0,0 +1,82 @@
+package request
+
+import (
+	"time"
+
+	"github.com/kataras/iris/v12"
+)
+
+// TemplateOption configures a single ControllerTemplate call.
+type TemplateOption func(*templateOptions)
+
+type templateOptions struct {
+	allFieldErrors bool
+	timeout        time.Duration
+	// idempotency, see WithIdempotency
+	idempotencyStore IdempotencyStore
+	idempotencyTTL   time.Duration
+	idempotencyLock  time.Duration
+	idempotencyScope func(ctx iris.Context) string
+	// media types, see WithConsumes and WithProduces
+	consumes []string
+	produces []string
+	// documentation recorded by Router
+	summary string
+	tags    []string
+}
+
+func newTemplateOptions(opts []TemplateOption) *templateOptions {
+	o := &templateOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithAllFieldErrors validates every field and returns all failures in response.Result.Errors
+// instead of stopping at the first one.
+func WithAllFieldErrors() TemplateOption {
+	return func(o *templateOptions) {
+		o.allFieldErrors = true
+	}
+}
+
+// WithTimeout bounds the business function's context; when the deadline is exceeded
+// the request fails with REQUEST_TIMEOUT (HTTP 504). Only ControllerTemplateCtx
+// functions observe the deadline.
+func WithTimeout(timeout time.Duration) TemplateOption {
+	return func(o *templateOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithConsumes restricts the request body media types; other bodies fail with
+// UNSUPPORTED_MEDIA_TYPE (HTTP 415). Requests without a body are not affected.
+func WithConsumes(mediaTypes ...string) TemplateOption {
+	return func(o *templateOptions) {
+		o.consumes = append(o.consumes, mediaTypes...)
+	}
+}
+
+// WithProduces restricts the response media types negotiated from Accept, in order of
+// preference; when none is acceptable the request fails with NOT_ACCEPTABLE (HTTP 406).
+// Each media type needs a registered Codec. XML is only offered to routes that list it here.
+func WithProduces(mediaTypes ...string) TemplateOption {
+	return func(o *templateOptions) {
+		o.produces = append(o.produces, mediaTypes...)
+	}
+}
+
+// WithSummary sets the route summary recorded by Router for API documentation.
+func WithSummary(summary string) TemplateOption {
+	return func(o *templateOptions) {
+		o.summary = summary
+	}
+}
+
+// WithTags sets the route tags recorded by Router for API documentation.
+func WithTags(tags ...string) TemplateOption {
+	return func(o *templateOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
@@ -0,0 +1,50 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicReporter receives panics recovered from business functions, e.g. to forward
+// them to an alerting system. It runs synchronously before the response is written.
+type PanicReporter func(ctx context.Context, recovered any, stack []byte)
+
+var panicReporter atomic.Pointer[PanicReporter]
+
+// SetPanicReporter installs the reporter called for every recovered panic; nil removes it.
+func SetPanicReporter(reporter PanicReporter) {
+	if reporter == nil {
+		panicReporter.Store(nil)
+		return
+	}
+	panicReporter.Store(&reporter)
+}
+
+// callBusiness runs f and recovers a panic, logging the stack with the trace ID.
+func callBusiness[Params any](ctx context.Context, f func(ctx context.Context, p Params) BusinessResult, params Params) (result BusinessResult, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			stack := debug.Stack()
+			slog.ErrorContext(ctx, fmt.Sprintf("Panic in business logic: %v", r), "stack", string(stack))
+			reportPanic(ctx, r, stack)
+		}
+	}()
+	return f(ctx, params), false
+}
+
+func reportPanic(ctx context.Context, recovered any, stack []byte) {
+	reporter := panicReporter.Load()
+	if reporter == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, fmt.Sprintf("Panic reporter failed: %v", r))
+		}
+	}()
+	(*reporter)(ctx, recovered, stack)
+}
@@ -1,58 +1,243 @@
 package request
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 
 	"github.com/bravpei/webtools/external/pkg/response"
-	"github.com/gookit/validate"
 	"github.com/kataras/iris/v12"
 )
 
-// BusinessResult defines the unified return structure for business logic
+// BusinessResult defines the unified return structure for business logic.
+// ErrCode should be a code registered with response.Register; it defaults to BUSINESS_ERROR.
+// A successful result with a Responder is written by it instead of the JSON envelope.
 type BusinessResult struct {
-	Data    any
-	ErrCode string
-	Error   error
+	Data      any
+	ErrCode   string
+	Error     error
+	Responder Responder
 }
 
-// ControllerTemplate is a template function for handling requests with JSON parameters.
-func ControllerTemplate[Params any](ctx iris.Context, f func(p Params) BusinessResult) {
+// TypedResult is BusinessResult with typed data, returned by the functions of
+// ControllerTemplateOf, ControllerTemplateCtxOf and HandleOf.
+type TypedResult[T any] struct {
+	Data      T
+	ErrCode   string
+	Error     error
+	Responder Responder
+}
+
+func (r TypedResult[T]) untyped() BusinessResult {
+	return BusinessResult{Data: r.Data, ErrCode: r.ErrCode, Error: r.Error, Responder: r.Responder}
+}
+
+// Fail returns a failed result with a registered error code.
+func Fail(code response.ErrorCode, err error) BusinessResult {
+	return BusinessResult{ErrCode: code.Code, Error: err}
+}
+
+// FailOf is Fail for a TypedResult.
+func FailOf[T any](code response.ErrorCode, err error) TypedResult[T] {
+	return TypedResult[T]{ErrCode: code.Code, Error: err}
+}
+
+// ControllerTemplate is a template function for handling requests.
+// The body is decoded first, then fields tagged with `query`, `form`, `header` or `path`
+// are bound from the corresponding request source. Form fields may be
+// *multipart.FileHeader or []*multipart.FileHeader for file uploads.
+// Errors are written with the HTTP status and result category of their registered code,
+// and messages are translated into the locale negotiated from Accept-Language.
+// Bodies are decoded and results encoded with the Codec matching Content-Type and Accept,
+// see RegisterCodec, WithConsumes and WithProduces.
+func ControllerTemplate[Params any](ctx iris.Context, f func(p Params) BusinessResult, opts ...TemplateOption) {
+	ControllerTemplateCtx(ctx, func(_ context.Context, p Params) BusinessResult {
+		return f(p)
+	}, opts...)
+}
+
+// ControllerTemplateOf is ControllerTemplate for business functions returning a TypedResult.
+func ControllerTemplateOf[Params, T any](ctx iris.Context, f func(p Params) TypedResult[T], opts ...TemplateOption) {
+	ControllerTemplateCtx(ctx, func(_ context.Context, p Params) BusinessResult {
+		return f(p).untyped()
+	}, opts...)
+}
+
+// ControllerTemplateCtx is ControllerTemplate for business functions that take a context.
+// The context carries the trace ID and claims set by middleware, is cancelled when the
+// client disconnects and has the deadline configured by WithTimeout. REQUEST_TIMEOUT is
+// written only when f returns the context's error; a result returned after the deadline
+// is still written. A panic in f is recovered and reported as INTERNAL_ERROR (HTTP 500),
+// see SetPanicReporter.
+func ControllerTemplateCtx[Params any](ctx iris.Context, f func(ctx context.Context, p Params) BusinessResult, opts ...TemplateOption) {
 	var params Params
+	options := newTemplateOptions(opts)
+	startTrace(ctx)
+	locale := Locale(ctx)
+	reqCtx := ctx.Request().Context()
+
+	// Content negotiation
+	codec, ok := negotiateCodec(ctx, options.produces)
+	if !ok {
+		writeError(ctx, response.CodeNotAcceptable, response.CodeNotAcceptable.Localize(locale))
+		return
+	}
+	ctx.Values().Set(codecKey, codec)
+
+	// Idempotent requests are fingerprinted before binding consumes the body
+	fingerprint, err := idempotencyFingerprint(ctx, options)
+	if err != nil {
+		slog.ErrorContext(reqCtx, fmt.Sprintf("Failed to read request body: %v", err))
+		if maxErr := (*http.MaxBytesError)(nil); errors.As(err, &maxErr) {
+			writeError(ctx, response.CodeRequestTooLarge, response.CodeRequestTooLarge.Localize(locale))
+			return
+		}
+		writeErrorDetails(ctx, response.CodeParamParse, response.CodeParamParse.Localize(locale), err)
+		return
+	}
 
 	// Parameter parsing
-	if err := ctx.ReadBody(&params); err != nil {
-		slog.Error(fmt.Sprintf("Failed to parse parameters: %v", err))
-		_ = ctx.JSON(response.Fail("PARAM_PARSE_ERROR", err.Error()))
+	if err := readParams(ctx, &params, options.consumes); err != nil {
+		if errors.Is(err, errUnsupportedMediaType) {
+			slog.ErrorContext(reqCtx, fmt.Sprintf("Failed to parse parameters: %v", err))
+			writeErrorDetails(ctx, response.CodeUnsupportedMediaType, response.CodeUnsupportedMediaType.Localize(locale), err)
+			return
+		}
+		slog.ErrorContext(reqCtx, fmt.Sprintf("Failed to parse parameters: %v", err))
+		writeErrorDetails(ctx, response.CodeParamParse, response.CodeParamParse.Localize(locale), err)
 		return
 	}
 
 	// Parameter validation
-	if err := validateParams(params); err != nil {
-		slog.Error(fmt.Sprintf("Failed to validate parameters: %v", err))
-		_ = ctx.JSON(response.Fail("PARAM_VALIDATE_ERROR", err.Error()))
+	if errs := validateParams(params, locale, options.allFieldErrors); len(errs) > 0 {
+		slog.ErrorContext(reqCtx, fmt.Sprintf("Failed to validate parameters: %v", errs))
+		if options.allFieldErrors {
+			writeError(ctx, response.CodeParamValidate, errs[0].Message, errs...)
+		} else {
+			writeError(ctx, response.CodeParamValidate, errs[0].Message)
+		}
+		return
+	}
+
+	idem, ok := acquireIdempotency(ctx, options, locale, fingerprint)
+	if !ok {
 		return
 	}
 
 	// Business logic processing
-	result := f(params)
-	if result.Error != nil {
-		slog.Error(fmt.Sprintf("Failed to process business logic: %v", result.Error))
-		errCode := result.ErrCode
-		if errCode == "" {
-			errCode = "BUSINESS_ERROR"
+	bizCtx, cancel := reqCtx, context.CancelFunc(func() {})
+	if options.timeout > 0 {
+		bizCtx, cancel = context.WithTimeout(reqCtx, options.timeout)
+	}
+	defer cancel()
+	result, panicked := callBusiness(bizCtx, f, params)
+
+	status, res := http.StatusOK, response.Succeed(result.Data)
+	switch {
+	case panicked:
+		status, res = errorResult(response.CodeInternal, response.CodeInternal.Localize(locale))
+	case errors.Is(result.Error, context.Canceled) && reqCtx.Err() != nil:
+		// The client went away and f gave up, nobody reads the response
+		slog.WarnContext(reqCtx, "Request cancelled by client")
+		idem.release(reqCtx)
+		return
+	case errors.Is(result.Error, context.DeadlineExceeded) && errors.Is(bizCtx.Err(), context.DeadlineExceeded):
+		slog.ErrorContext(reqCtx, fmt.Sprintf("Business logic timed out after %v: %v", options.timeout, result.Error))
+		status, res = errorResult(response.CodeTimeout, response.CodeTimeout.Localize(locale))
+	case result.Error != nil:
+		slog.ErrorContext(reqCtx, fmt.Sprintf("Failed to process business logic: %v", result.Error))
+		status, res = errorResult(businessError(result, locale))
+	}
+
+	if result.Error == nil && result.Responder != nil && status == http.StatusOK {
+		// Streamed responses can't be replayed, the key only records that the request completed
+		idem.completeStreamed(reqCtx)
+		if err := result.Responder.Respond(ctx); err != nil {
+			slog.ErrorContext(reqCtx, fmt.Sprintf("Failed to write response: %v", err))
 		}
-		_ = ctx.JSON(response.Fail(errCode, result.Error.Error()))
 		return
 	}
 
-	_ = ctx.JSON(response.Succeed(result.Data))
+	idem.complete(reqCtx, status, res)
+	writeResult(ctx, status, res)
+}
+
+// ControllerTemplateCtxOf is ControllerTemplateCtx for business functions returning a TypedResult.
+func ControllerTemplateCtxOf[Params, T any](ctx iris.Context, f func(ctx context.Context, p Params) TypedResult[T], opts ...TemplateOption) {
+	ControllerTemplateCtx(ctx, func(c context.Context, p Params) BusinessResult {
+		return f(c, p).untyped()
+	}, opts...)
 }
 
-func validateParams(params any) error {
-	if v := validate.Struct(params); !v.Validate() {
-		return errors.New(v.Errors.One())
+// businessError resolves the error code and localized message of a failed result.
+// A translation registered for the code wins over the error text; a response.CodeError
+// is localized with its arguments.
+func businessError(result BusinessResult, locale string) (response.ErrorCode, string) {
+	var codeErr *response.CodeError
+	isCodeErr := errors.As(result.Error, &codeErr)
+
+	errCode := result.ErrCode
+	if errCode == "" && isCodeErr {
+		errCode = codeErr.Code.Code
+	}
+	if errCode == "" {
+		errCode = response.CodeBusiness.Code
+	}
+	code, _ := response.Lookup(errCode)
+
+	if isCodeErr && codeErr.Code.Code == code.Code {
+		return code, codeErr.Localize(locale)
+	}
+	if message, ok := response.Translate(locale, code.Code); ok {
+		return code, message
+	}
+	return code, result.Error.Error()
+}
+
+// writeError writes an error result using the code's HTTP status and category.
+func writeError(ctx iris.Context, code response.ErrorCode, message string, fieldErrors ...response.FieldError) {
+	status, result := errorResult(code, message)
+	result.Errors = fieldErrors
+	writeResult(ctx, status, result)
+}
+
+// writeErrorDetails writes an error result whose details carry err's message.
+func writeErrorDetails(ctx iris.Context, code response.ErrorCode, message string, err error) {
+	status, result := errorResult(code, message)
+	result.Details = err.Error()
+	writeResult(ctx, status, result)
+}
+
+// errorResult builds the error result and HTTP status of a code.
+func errorResult(code response.ErrorCode, message string) (int, response.Result) {
+	return code.HTTPStatus, code.Result(message)
+}
+
+// writeResult writes the result with the request's trace ID, encoded with the negotiated codec.
+// The result is encoded before the status is committed; when the codec fails, an
+// INTERNAL_ERROR result is written as JSON instead.
+func writeResult(ctx iris.Context, status int, result response.Result) {
+	result.TraceId = TraceID(ctx)
+	codec := responseCodec(ctx)
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, result); err != nil {
+		slog.ErrorContext(ctx.Request().Context(), fmt.Sprintf("Failed to encode response as %s: %v", codec.MediaType(), err))
+		status, result = errorResult(response.CodeInternal, response.CodeInternal.Localize(Locale(ctx)))
+		result.TraceId = TraceID(ctx)
+		codec = jsonCodec{}
+		buf.Reset()
+		if err = codec.Encode(&buf, result); err != nil {
+			slog.ErrorContext(ctx.Request().Context(), fmt.Sprintf("Failed to encode response: %v", err))
+			ctx.StatusCode(http.StatusInternalServerError)
+			return
+		}
+	}
+	ctx.ContentType(codec.MediaType())
+	ctx.StatusCode(status)
+	if _, err := ctx.Write(buf.Bytes()); err != nil {
+		slog.ErrorContext(ctx.Request().Context(), fmt.Sprintf("Failed to write response: %v", err))
 	}
-	return nil
 }
@@ -0,0 +1,747 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bravpei/webtools/external/pkg/response"
+	"github.com/bravpei/webtools/external/pkg/trace"
+	"github.com/kataras/iris/v12"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type pageQuery struct {
+	Page int `query:"page"`
+	Size int `query:"size"`
+}
+
+type updateUserParams struct {
+	pageQuery
+	ID     int64    `path:"id"`
+	Tenant string   `header:"X-Tenant" validate:"required"`
+	Name   string   `json:"name"`
+	Tags   []string `query:"tag"`
+}
+
+type uploadParams struct {
+	Title string                `form:"title"`
+	File  *multipart.FileHeader `form:"file"`
+	ID    int64                 `path:"id"`
+}
+
+func serve(t *testing.T, app *iris.Application, req *http.Request, status ...int) response.Result {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	if want := append(status, http.StatusOK)[0]; rec.Code != want {
+		t.Fatalf("status %d, want %d: %s", rec.Code, want, rec.Body.String())
+	}
+	var result response.Result
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+	return result
+}
+
+func TestControllerTemplateBinding(t *testing.T) {
+	app := iris.New()
+	app.Put("/users/{id:int64}", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p updateUserParams) BusinessResult {
+			return BusinessResult{Data: p}
+		})
+	})
+	app.Get("/users", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p pageQuery) BusinessResult {
+			return BusinessResult{Data: p.Page * p.Size}
+		})
+	})
+	app.Delete("/users/{id:int64}", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p updateUserParams) BusinessResult {
+			return BusinessResult{Data: p.ID}
+		})
+	})
+	app.Post("/users/{id:int64}/avatar", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p uploadParams) BusinessResult {
+			f, err := p.File.Open()
+			if err != nil {
+				return BusinessResult{Error: err}
+			}
+			defer f.Close()
+			content, _ := io.ReadAll(f)
+			return BusinessResult{Data: map[string]any{"id": p.ID, "title": p.Title, "file": p.File.Filename, "content": string(content)}}
+		})
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/users/42?page=2&size=10&tag=a&tag=b", strings.NewReader(`{"name":"alice"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tenant", "acme")
+	result := serve(t, app, req)
+	data, _ := json.Marshal(result.Data)
+	var got updateUserParams
+	_ = json.Unmarshal(data, &got)
+	if result.ErrCode != "" || got.Page != 2 || got.Size != 10 || got.ID != 42 || got.Tenant != "acme" ||
+		got.Name != "alice" || strings.Join(got.Tags, ",") != "a,b" {
+		t.Fatalf("got %s %s", result.ErrCode, data)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/users?page=3&size=20", nil)
+	if result = serve(t, app, req); result.ErrCode != "" || result.Data != float64(60) {
+		t.Fatalf("got %+v", result)
+	}
+
+	// No body: only path, query and header are bound
+	req = httptest.NewRequest(http.MethodDelete, "/users/7", nil)
+	req.Header.Set("X-Tenant", "acme")
+	if result = serve(t, app, req); result.ErrCode != "" || result.Data != float64(7) {
+		t.Fatalf("got %+v", result)
+	}
+
+	// Bound values are validated
+	req = httptest.NewRequest(http.MethodDelete, "/users/7", nil)
+	if result = serve(t, app, req, http.StatusBadRequest); result.ErrCode != "PARAM_VALIDATE_ERROR" || result.Status != response.StatusValidationError {
+		t.Fatalf("got %+v", result)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("title", "me")
+	part, _ := writer.CreateFormFile("file", "avatar.png")
+	_, _ = part.Write([]byte("png"))
+	_ = writer.Close()
+	req = httptest.NewRequest(http.MethodPost, "/users/9/avatar", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	result = serve(t, app, req)
+	data, _ = json.Marshal(result.Data)
+	if string(data) != `{"content":"png","file":"avatar.png","id":9,"title":"me"}` {
+		t.Fatalf("got %s %s", result.ErrCode, data)
+	}
+}
+
+var errUserNotFound = response.Register(response.ErrorCode{
+	Code: "TEST_USER_NOT_FOUND", Message: "user not found", HTTPStatus: http.StatusNotFound, Status: response.StatusFailure,
+})
+
+func TestControllerTemplateErrorCode(t *testing.T) {
+	app := iris.New()
+	app.Get("/users/{id:int64}", func(ctx iris.Context) {
+		ControllerTemplateOf(ctx, func(p updateUserParams) TypedResult[string] {
+			return FailOf[string](errUserNotFound, errors.New(""))
+		})
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("X-Tenant", "acme")
+	result := serve(t, app, req, http.StatusNotFound)
+	if result.ErrCode != "TEST_USER_NOT_FOUND" || result.Message != "user not found" || result.Status != response.StatusFailure {
+		t.Fatalf("got %+v", result)
+	}
+}
+
+type signupParams struct {
+	UserName string `json:"user_name" validate:"required"`
+	Age      int    `json:"age" validate:"required|min:18"`
+}
+
+var errTooYoung = response.Register(response.ErrorCode{
+	Code: "TEST_TOO_YOUNG", Message: "must be at least %d", HTTPStatus: http.StatusForbidden, Status: response.StatusFailure,
+})
+
+func TestControllerTemplateLocale(t *testing.T) {
+	response.RegisterCatalog("zh-CN", response.Catalog{
+		Messages: map[string]string{errTooYoung.Code: "年龄不能小于%d"},
+		Fields:   map[string]string{"UserName": "用户名"},
+	})
+	app := iris.New()
+	app.Post("/signup", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p signupParams) BusinessResult {
+			return BusinessResult{Error: errTooYoung.Err(21)}
+		}, WithAllFieldErrors())
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(body, lang string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Language", lang)
+		return req
+	}
+
+	result := serve(t, app, post(`{"age":1}`, "fr;q=0.9, zh;q=0.8"), http.StatusBadRequest)
+	if len(result.Errors) != 2 || result.Errors[1].Field != "user_name" || result.Errors[1].Message != "用户名 是必填项" ||
+		result.Errors[0].Rule != "min" || result.Message != result.Errors[0].Message {
+		t.Fatalf("got %+v", result)
+	}
+
+	result = serve(t, app, post(`{"age":1}`, "en-US"), http.StatusBadRequest)
+	if len(result.Errors) != 2 || !strings.Contains(result.Errors[1].Message, "is required") {
+		t.Fatalf("got %+v", result)
+	}
+
+	result = serve(t, app, post(`{"user_name":"a","age":20}`, "zh-CN"), http.StatusForbidden)
+	if result.ErrCode != errTooYoung.Code || result.Message != "年龄不能小于21" {
+		t.Fatalf("got %+v", result)
+	}
+	result = serve(t, app, post(`{"user_name":"a","age":20}`, ""), http.StatusForbidden)
+	if result.Message != "must be at least 21" {
+		t.Fatalf("got %+v", result)
+	}
+
+	// Parse failures keep the underlying error in details
+	result = serve(t, app, post(`{"age":"old"}`, "zh-CN"), http.StatusBadRequest)
+	if result.ErrCode != response.CodeParamParse.Code || result.Message != "参数解析失败" || !strings.Contains(result.Details, "age") {
+		t.Fatalf("got %+v", result)
+	}
+
+	// Catalog accessors return copies
+	response.FieldNames("zh-CN")["UserName"] = "changed"
+	if response.FieldNames("zh-CN")["UserName"] != "用户名" {
+		t.Fatal("FieldNames returned the shared catalog map")
+	}
+}
+
+func TestControllerTemplateTrace(t *testing.T) {
+	app := iris.New()
+	app.Get("/trace", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p struct{}) BusinessResult {
+			return BusinessResult{Data: TraceID(ctx)}
+		})
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/trace", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, req)
+	var result response.Result
+	_ = json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || result.Data != result.TraceId ||
+		rec.Header().Get("X-Request-Id") != result.TraceId {
+		t.Fatalf("got %+v, header %q", result, rec.Header().Get("X-Request-Id"))
+	}
+
+	// Without incoming headers a new trace ID is generated
+	result = serve(t, app, httptest.NewRequest(http.MethodGet, "/trace", nil))
+	if len(result.TraceId) != 32 {
+		t.Fatalf("got %+v", result)
+	}
+}
+
+type testClaims struct {
+	UserID int64
+}
+
+func TestControllerTemplateCtx(t *testing.T) {
+	app := iris.New()
+	app.Use(func(ctx iris.Context) {
+		SetClaims(ctx, testClaims{UserID: 7})
+		ctx.Next()
+	})
+	app.Get("/me", func(ctx iris.Context) {
+		ControllerTemplateCtxOf(ctx, func(c context.Context, p struct{}) TypedResult[int64] {
+			claims, ok := ClaimsFrom[testClaims](c)
+			if !ok || trace.FromContext(c) == "" {
+				return TypedResult[int64]{Error: errors.New("missing claims or trace id")}
+			}
+			return TypedResult[int64]{Data: claims.UserID}
+		})
+	})
+	app.Get("/slow", func(ctx iris.Context) {
+		ControllerTemplateCtx(ctx, func(c context.Context, p struct{}) BusinessResult {
+			select {
+			case <-c.Done():
+				return BusinessResult{Error: c.Err()}
+			case <-time.After(time.Second):
+				return BusinessResult{Data: "done"}
+			}
+		}, WithTimeout(20*time.Millisecond))
+	})
+	var lateCalls atomic.Int32
+	store := NewMemoryIdempotencyStore(10)
+	app.Post("/late", func(ctx iris.Context) {
+		ControllerTemplateCtx(ctx, func(c context.Context, p struct{}) BusinessResult {
+			// Ignores the deadline and completes the work anyway
+			time.Sleep(30 * time.Millisecond)
+			return BusinessResult{Data: lateCalls.Add(1)}
+		}, WithTimeout(5*time.Millisecond), WithIdempotency(store, time.Minute))
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := serve(t, app, httptest.NewRequest(http.MethodGet, "/me", nil)); result.Data != float64(7) {
+		t.Fatalf("got %+v", result)
+	}
+	result := serve(t, app, httptest.NewRequest(http.MethodGet, "/slow", nil), http.StatusGatewayTimeout)
+	if result.ErrCode != response.CodeTimeout.Code || result.Status != response.StatusServerError {
+		t.Fatalf("got %+v", result)
+	}
+
+	// A result returned after the deadline is written and kept for replay
+	late := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/late", nil)
+		req.Header.Set(HeaderIdempotencyKey, "late")
+		return req
+	}
+	for i := 0; i < 2; i++ {
+		if result := serve(t, app, late()); result.Data != float64(1) {
+			t.Fatalf("late #%d %+v", i, result)
+		}
+	}
+	if lateCalls.Load() != 1 {
+		t.Fatalf("%d calls", lateCalls.Load())
+	}
+}
+
+func TestControllerTemplatePanic(t *testing.T) {
+	reported := make(chan any, 1)
+	SetPanicReporter(func(ctx context.Context, recovered any, stack []byte) {
+		if trace.FromContext(ctx) == "" || len(stack) == 0 {
+			t.Error("missing trace id or stack")
+		}
+		reported <- recovered
+	})
+	defer SetPanicReporter(nil)
+
+	app := iris.New()
+	app.Get("/panic", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p struct{}) BusinessResult {
+			panic("boom")
+		})
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := serve(t, app, httptest.NewRequest(http.MethodGet, "/panic", nil), http.StatusInternalServerError)
+	if result.ErrCode != "INTERNAL_ERROR" || result.Status != response.StatusServerError || result.TraceId == "" {
+		t.Fatalf("got %+v", result)
+	}
+	if r := <-reported; r != "boom" {
+		t.Fatalf("reported %v", r)
+	}
+}
+
+func TestControllerTemplateIdempotency(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	store := &ttlRecordingStore{IdempotencyStore: NewMemoryIdempotencyStore(10)}
+
+	app := iris.New()
+	app.Configure(iris.WithPostMaxMemory(1024))
+	app.Use(func(ctx iris.Context) {
+		if user := ctx.GetHeader("X-User"); user != "" {
+			SetClaims(ctx, testClaims{UserID: int64(len(user))})
+		}
+		ctx.Next()
+	})
+	app.Post("/orders", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p struct{}) BusinessResult {
+			n := calls.Add(1)
+			if ctx.GetHeader("X-Block") != "" {
+				<-release
+			}
+			return BusinessResult{Data: n}
+		}, WithIdempotency(store, time.Minute), WithIdempotencyLockTTL(time.Second))
+	})
+	var exports atomic.Int32
+	app.Post("/exports", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p struct{}) BusinessResult {
+			exports.Add(1)
+			return Respond(Raw("text/csv", []byte("id\n")))
+		}, WithIdempotency(store, time.Minute))
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	post := func(key string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"amount":1}`))
+		req.Header.Set("Content-Type", MediaTypeJSON)
+		req.Header.Set(HeaderIdempotencyKey, key)
+		return req
+	}
+
+	first := serve(t, app, post("a"))
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, post("a"))
+	if rec.Header().Get(HeaderIdempotencyReplayed) != "true" || !strings.Contains(rec.Body.String(), `"data":1`) {
+		t.Fatalf("replay %q %q", rec.Header(), rec.Body.String())
+	}
+	if first.Data != float64(1) || calls.Load() != 1 {
+		t.Fatalf("first %+v, %d calls", first, calls.Load())
+	}
+	if result := serve(t, app, post("b")); result.Data != float64(2) {
+		t.Fatalf("other key %+v", result)
+	}
+
+	// A duplicate of a request still in flight is rejected
+	blocked := post("c")
+	blocked.Header.Set("X-Block", "1")
+	done := make(chan response.Result)
+	go func() {
+		done <- serve(t, app, blocked)
+	}()
+	for calls.Load() != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if result := serve(t, app, post("c"), http.StatusConflict); result.ErrCode != response.CodeInFlight.Code {
+		t.Fatalf("in flight %+v", result)
+	}
+	close(release)
+	if result := <-done; result.Data != float64(3) {
+		t.Fatalf("blocked %+v", result)
+	}
+
+	// Keys are scoped by the caller's claims
+	other := post("a")
+	other.Header.Set("X-User", "bob")
+	if result := serve(t, app, other); result.Data != float64(4) {
+		t.Fatalf("other caller %+v", result)
+	}
+	// Reusing a key for a different request is rejected
+	changed := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"amount":2}`))
+	changed.Header.Set("Content-Type", MediaTypeJSON)
+	changed.Header.Set(HeaderIdempotencyKey, "a")
+	if result := serve(t, app, changed, http.StatusUnprocessableEntity); result.ErrCode != response.CodeIdempotencyMismatch.Code {
+		t.Fatalf("changed body %+v", result)
+	}
+	// Running requests hold the key for the lock ttl, results are kept for the full ttl
+	if store.acquireTTL.Load() != int64(time.Second) || store.saveTTL.Load() != int64(time.Minute) {
+		t.Fatalf("acquire ttl %v, save ttl %v", time.Duration(store.acquireTTL.Load()), time.Duration(store.saveTTL.Load()))
+	}
+
+	// Fingerprinting buffers at most PostMaxMemory of the body
+	large := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"note":"`+strings.Repeat("a", 1024)+`"}`))
+	large.Header.Set("Content-Type", MediaTypeJSON)
+	large.Header.Set(HeaderIdempotencyKey, "large")
+	if result := serve(t, app, large, http.StatusRequestEntityTooLarge); result.ErrCode != response.CodeRequestTooLarge.Code {
+		t.Fatalf("large body %+v", result)
+	}
+
+	// Keys are hashed before they reach the store, oversized headers are rejected
+	if serve(t, app, post(strings.Repeat("k", MaxIdempotencyKeyLength))); len(*store.key.Load()) != 64 {
+		t.Fatalf("stored key %q", *store.key.Load())
+	}
+	if result := serve(t, app, post(strings.Repeat("k", MaxIdempotencyKeyLength+1)), http.StatusBadRequest); result.ErrCode != response.CodeIdempotencyKeyInvalid.Code {
+		t.Fatalf("long key %+v", result)
+	}
+
+	// A Responder runs once, retries are rejected instead of repeating the work
+	export := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/exports", nil)
+		req.Header.Set(HeaderIdempotencyKey, "export")
+		return req
+	}
+	rec = httptest.NewRecorder()
+	app.ServeHTTP(rec, export())
+	if rec.Body.String() != "id\n" {
+		t.Fatalf("export %q", rec.Body.String())
+	}
+	if result := serve(t, app, export(), http.StatusConflict); result.ErrCode != response.CodeIdempotencyStreamed.Code {
+		t.Fatalf("export retry %+v", result)
+	}
+	if exports.Load() != 1 {
+		t.Fatalf("%d exports", exports.Load())
+	}
+}
+
+// ttlRecordingStore records the ttls passed to the wrapped store.
+type ttlRecordingStore struct {
+	IdempotencyStore
+	acquireTTL atomic.Int64
+	saveTTL    atomic.Int64
+	key        atomic.Pointer[string]
+}
+
+func (s *ttlRecordingStore) Acquire(ctx context.Context, key string, ttl time.Duration) (*StoredResult, bool, error) {
+	s.acquireTTL.Store(int64(ttl))
+	s.key.Store(&key)
+	return s.IdempotencyStore.Acquire(ctx, key, ttl)
+}
+
+func (s *ttlRecordingStore) Save(ctx context.Context, key string, result StoredResult, ttl time.Duration) error {
+	s.saveTTL.Store(int64(ttl))
+	return s.IdempotencyStore.Save(ctx, key, result, ttl)
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIdempotencyStore(2)
+	for _, key := range []string{"a", "b", "c"} {
+		if _, acquired, _ := store.Acquire(ctx, key, time.Minute); !acquired {
+			t.Fatalf("%s not acquired", key)
+		}
+	}
+	// a was evicted, c is still in flight
+	if _, acquired, _ := store.Acquire(ctx, "a", time.Minute); !acquired {
+		t.Fatal("evicted key not acquired")
+	}
+	if stored, acquired, _ := store.Acquire(ctx, "c", time.Minute); stored != nil || acquired {
+		t.Fatal("in-flight key acquired")
+	}
+
+	_ = store.Release(ctx, "a")
+	if _, acquired, _ := store.Acquire(ctx, "a", time.Minute); !acquired {
+		t.Fatal("released key not acquired")
+	}
+	_ = store.Save(ctx, "a", StoredResult{HTTPStatus: http.StatusOK}, -time.Second)
+	if stored, acquired, _ := store.Acquire(ctx, "a", time.Minute); stored != nil || !acquired {
+		t.Fatal("expired result replayed")
+	}
+}
+
+func TestControllerTemplateResponder(t *testing.T) {
+	file := t.TempDir() + "/report.txt"
+	if err := os.WriteFile(file, []byte("report"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := iris.New()
+	app.Get("/export", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p struct {
+			Rows int `query:"rows" validate:"min:1"`
+		}) BusinessResult {
+			return Respond(Attachment("users.csv", Stream("text/csv", func(w io.Writer) error {
+				for i := 0; i < p.Rows; i++ {
+					if _, err := fmt.Fprintf(w, "%d,user%d\n", i, i); err != nil {
+						return err
+					}
+				}
+				return nil
+			})))
+		})
+	})
+	app.Get("/file", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p struct{}) BusinessResult {
+			return Respond(File(file, "download.txt"))
+		})
+	})
+	app.Get("/raw", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p struct{}) BusinessResult {
+			return Respond(Raw("image/svg+xml", []byte("<svg/>")))
+		})
+	})
+	app.Get("/events", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p struct{}) BusinessResult {
+			events := make(chan Event, 2)
+			events <- Event{ID: "1", Event: "greeting", Data: "hello\nworld"}
+			events <- Event{Data: map[string]int{"n": 2}}
+			close(events)
+			return Respond(SSE(events))
+		})
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := get("/export?rows=2")
+	if rec.Body.String() != "0,user0\n1,user1\n" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") ||
+		!strings.Contains(rec.Header().Get("Content-Disposition"), "users.csv") {
+		t.Errorf("export %q %q", rec.Header(), rec.Body.String())
+	}
+	// Validation still returns the JSON envelope
+	if result := serve(t, app, httptest.NewRequest(http.MethodGet, "/export?rows=-1", nil), http.StatusBadRequest); result.ErrCode != response.CodeParamValidate.Code {
+		t.Errorf("invalid export %+v", result)
+	}
+
+	rec = get("/file")
+	if rec.Body.String() != "report" || !strings.Contains(rec.Header().Get("Content-Disposition"), "download.txt") {
+		t.Errorf("file %q %q", rec.Header(), rec.Body.String())
+	}
+	rec = get("/raw")
+	if rec.Body.String() != "<svg/>" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "image/svg+xml") {
+		t.Errorf("raw %q %q", rec.Header(), rec.Body.String())
+	}
+	rec = get("/events")
+	want := "id: 1\nevent: greeting\ndata: hello\ndata: world\n\ndata: {\"n\":2}\n\n"
+	if rec.Body.String() != want || rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("events %q %q", rec.Header(), rec.Body.String())
+	}
+}
+
+func TestControllerTemplateNegotiation(t *testing.T) {
+	type echoParams struct {
+		Name string `json:"name" xml:"name"`
+	}
+	app := iris.New()
+	app.Post("/echo", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p echoParams) BusinessResult {
+			return BusinessResult{Data: "hello " + p.Name}
+		})
+	})
+	app.Post("/json", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p echoParams) BusinessResult {
+			return BusinessResult{Data: p.Name}
+		}, WithConsumes(MediaTypeJSON), WithProduces(MediaTypeJSON))
+	})
+	app.Post("/xml", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p echoParams) BusinessResult {
+			return BusinessResult{Data: "hello " + p.Name}
+		}, WithProduces(MediaTypeXML, MediaTypeJSON))
+	})
+	app.Post("/map", func(ctx iris.Context) {
+		ControllerTemplate(ctx, func(p struct{}) BusinessResult {
+			return BusinessResult{Data: map[string]int{"a": 1}}
+		}, WithProduces(MediaTypeXML))
+	})
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	post := func(target, contentType, accept string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body, err := msgpack.Marshal(map[string]string{"name": "msgpack"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := post("/echo", MediaTypeMsgPack, "application/msgpack", body)
+	var result response.Result
+	if err := (msgpackCodec{}).Decode(rec.Body, &result); err != nil || result.Data != "hello msgpack" ||
+		!strings.HasPrefix(rec.Header().Get("Content-Type"), MediaTypeMsgPack) {
+		t.Fatalf("msgpack %v %+v", err, result)
+	}
+
+	rec = post("/xml", "application/xml; charset=utf-8", "text/html;q=0.9, application/xml", []byte("<echoParams><name>xml</name></echoParams>"))
+	if !strings.Contains(rec.Body.String(), "<Data>hello xml</Data>") {
+		t.Fatalf("xml %q", rec.Body.String())
+	}
+	// Wildcards pick JSON even when the route lists XML first
+	if rec = post("/xml", MediaTypeJSON, "*/*", []byte(`{"name":"json"}`)); !strings.HasPrefix(rec.Header().Get("Content-Type"), MediaTypeJSON) {
+		t.Fatalf("xml route wildcard %q", rec.Body.String())
+	}
+	// XML is only offered by routes listing it
+	if rec = post("/echo", MediaTypeJSON, MediaTypeXML, []byte(`{}`)); rec.Code != http.StatusNotAcceptable {
+		t.Fatalf("xml on default route %d %q", rec.Code, rec.Body.String())
+	}
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	if rec = post("/echo", MediaTypeJSON, browser, []byte(`{"name":"browser"}`)); !strings.Contains(rec.Body.String(), `"data":"hello browser"`) {
+		t.Fatalf("browser %q", rec.Body.String())
+	}
+	// A result the codec can't encode falls back to a JSON INTERNAL_ERROR
+	rec = post("/map", MediaTypeJSON, MediaTypeXML, []byte(`{}`))
+	if rec.Code != http.StatusInternalServerError || !strings.HasPrefix(rec.Header().Get("Content-Type"), MediaTypeJSON) ||
+		!strings.Contains(rec.Body.String(), "INTERNAL_ERROR") {
+		t.Fatalf("encode failure %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = post("/echo", MediaTypeJSON, MediaTypeProtobuf, []byte(`{"name":"proto"}`))
+	value := &structpb.Value{}
+	if err := (protobufCodec{}).Decode(rec.Body, &result); err != nil {
+		t.Fatalf("protobuf %v", err)
+	}
+	if err := result.Data.(*anypb.Any).UnmarshalTo(value); err != nil || value.GetStringValue() != "hello proto" || result.Message != "success" {
+		t.Fatalf("protobuf %v %+v", err, result)
+	}
+
+	// q=0 excludes JSON even when a wildcard matches it
+	rec = post("/echo", MediaTypeJSON, "application/json;q=0, */*", []byte(`{"name":"excluded"}`))
+	var excluded response.Result
+	if err := (msgpackCodec{}).Decode(rec.Body, &excluded); err != nil || excluded.Data != "hello excluded" ||
+		!strings.HasPrefix(rec.Header().Get("Content-Type"), MediaTypeMsgPack) {
+		t.Fatalf("json excluded %v %q %+v", err, rec.Header().Get("Content-Type"), excluded)
+	}
+	if rec = post("/json", MediaTypeJSON, "application/*, application/json;q=0", []byte(`{}`)); rec.Code != http.StatusNotAcceptable {
+		t.Fatalf("only json excluded %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = post("/echo", MediaTypeJSON, "*/*", []byte(`{"name":"json"}`))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), MediaTypeJSON) || !strings.Contains(rec.Body.String(), `"data":"hello json"`) {
+		t.Fatalf("json %q", rec.Body.String())
+	}
+
+	if rec = post("/json", MediaTypeJSON, MediaTypeMsgPack, []byte(`{}`)); rec.Code != http.StatusNotAcceptable || !strings.Contains(rec.Body.String(), "NOT_ACCEPTABLE") {
+		t.Fatalf("not acceptable %d %q", rec.Code, rec.Body.String())
+	}
+	if rec = post("/json", MediaTypeMsgPack, MediaTypeJSON, body); rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("unsupported media type %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestControllerTemplateProtobuf(t *testing.T) {
+	const id = int64(1)<<62 + 1 // not representable as a float64
+	app := iris.New()
+	router := NewRouter(app)
+	Handle(router, http.MethodPost, "/pointer", func(_ context.Context, p *wrapperspb.Int64Value) BusinessResult {
+		if p.GetValue() == 0 {
+			return Fail(response.CodeParamValidate, errors.New("value is required"))
+		}
+		return BusinessResult{Data: wrapperspb.Int64(p.GetValue())}
+	})
+	// Messages can't be copied, so message values are rejected as Params
+	func() {
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "use *wrapperspb.Int64Value") {
+				t.Errorf("value params recovered %v", r)
+			}
+		}()
+		router.handle(http.MethodPost, "/value", func(iris.Context) {}, reflect.TypeOf(wrapperspb.Int64Value{}), nil, nil)
+	}()
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+	post := func(target string, body []byte) (int, response.Result) {
+		req := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		req.Header.Set("Content-Type", MediaTypeProtobuf)
+		req.Header.Set("Accept", MediaTypeProtobuf)
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		var result response.Result
+		if err := (protobufCodec{}).Decode(rec.Body, &result); err != nil {
+			t.Fatalf("%s %v", target, err)
+		}
+		return rec.Code, result
+	}
+	body, err := proto.Marshal(wrapperspb.Int64(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Message data is packed natively, keeping int64 precision
+	_, result := post("/pointer", body)
+	data := &wrapperspb.Int64Value{}
+	if err := result.Data.(*anypb.Any).UnmarshalTo(data); err != nil || data.GetValue() != id || result.TraceId == "" {
+		t.Fatalf("%v %+v", err, result)
+	}
+	// Errors use the same envelope
+	if code, result := post("/pointer", nil); code != http.StatusBadRequest || result.ErrCode != response.CodeParamValidate.Code ||
+		result.Status != response.StatusValidationError || result.Data != nil {
+		t.Fatalf("error %d %+v", code, result)
+	}
+}
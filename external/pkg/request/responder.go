@@ -0,0 +1,156 @@
+package request
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kataras/iris/v12"
+	irisctx "github.com/kataras/iris/v12/context"
+)
+
+// Responder writes a successful response in place of the JSON envelope, e.g. a file
+// download, a CSV export or an event stream. Parameter and business errors are still
+// written as JSON results.
+type Responder interface {
+	Respond(ctx iris.Context) error
+}
+
+// Respond returns a successful result written by r.
+func Respond(r Responder) BusinessResult {
+	return BusinessResult{Responder: r}
+}
+
+// RespondOf is Respond for a TypedResult.
+func RespondOf[T any](r Responder) TypedResult[T] {
+	return TypedResult[T]{Responder: r}
+}
+
+// ResponderFunc adapts a function to Responder.
+type ResponderFunc func(ctx iris.Context) error
+
+func (f ResponderFunc) Respond(ctx iris.Context) error {
+	return f(ctx)
+}
+
+// File serves the file at path. A non-empty name sends it as an attachment with that file name,
+// otherwise it is served inline. Range and conditional requests are supported.
+func File(path, name string) Responder {
+	return ResponderFunc(func(ctx iris.Context) error {
+		if name == "" {
+			return ctx.ServeFile(path)
+		}
+		return ctx.SendFile(path, name)
+	})
+}
+
+// Raw writes data with the content type.
+func Raw(contentType string, data []byte) Responder {
+	return ResponderFunc(func(ctx iris.Context) error {
+		ctx.ContentType(contentType)
+		_, err := ctx.Write(data)
+		return err
+	})
+}
+
+// Stream writes the body with write, flushing every write to the client.
+func Stream(contentType string, write func(w io.Writer) error) Responder {
+	return ResponderFunc(func(ctx iris.Context) error {
+		ctx.ContentType(contentType)
+		return write(flushWriter{ctx.ResponseWriter()})
+	})
+}
+
+// Attachment makes the client save the response of r as a file with the name.
+func Attachment(name string, r Responder) Responder {
+	return ResponderFunc(func(ctx iris.Context) error {
+		ctx.Header(irisctx.ContentDispositionHeaderKey, irisctx.MakeDisposition(name))
+		return r.Respond(ctx)
+	})
+}
+
+type flushWriter struct {
+	w irisctx.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.w.Flush()
+	return n, err
+}
+
+// Event is a Server-Sent Event. Data is written as is when it is a string or []byte,
+// otherwise as JSON.
+type Event struct {
+	ID    string
+	Event string
+	Data  any
+	// Retry tells the client how many milliseconds to wait before reconnecting
+	Retry int
+}
+
+// SSE streams the events as text/event-stream until the channel is closed or the client disconnects.
+// The sender should stop when the business context is done.
+func SSE(events <-chan Event) Responder {
+	return ResponderFunc(func(ctx iris.Context) error {
+		header := ctx.ResponseWriter().Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		header.Set("X-Accel-Buffering", "no")
+		ctx.StatusCode(http.StatusOK)
+		ctx.ResponseWriter().Flush()
+
+		done := ctx.Request().Context().Done()
+		for {
+			select {
+			case <-done:
+				return ctx.Request().Context().Err()
+			case event, ok := <-events:
+				if !ok {
+					return nil
+				}
+				if err := writeEvent(ctx.ResponseWriter(), event); err != nil {
+					return err
+				}
+				ctx.ResponseWriter().Flush()
+			}
+		}
+	})
+}
+
+func writeEvent(w io.Writer, event Event) error {
+	var data string
+	switch v := event.Data.(type) {
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("encode event data: %w", err)
+		}
+		data = string(b)
+	}
+
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.Itoa(event.Retry) + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
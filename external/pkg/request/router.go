@@ -0,0 +1,86 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/kataras/iris/v12"
+)
+
+// RouteInfo describes a route registered through a Router, used to generate API documentation.
+type RouteInfo struct {
+	Method  string
+	Path    string // full iris path template, e.g. /api/users/{id:int64}
+	Summary string
+	Tags    []string
+	Params  reflect.Type
+	Data    reflect.Type // nil for routes registered with Handle
+}
+
+// Router registers ControllerTemplateCtx routes on an iris party and records their metadata.
+type Router struct {
+	party iris.Party
+	table *routeTable
+}
+
+// routeTable is shared by a Router and its sub routers.
+type routeTable struct {
+	mutex  sync.RWMutex
+	routes []RouteInfo
+}
+
+// NewRouter creates a Router on the party.
+func NewRouter(party iris.Party) *Router {
+	return &Router{party: party, table: &routeTable{}}
+}
+
+// Party returns a Router for a sub party sharing the same route records.
+func (r *Router) Party(relativePath string, middleware ...iris.Handler) *Router {
+	return &Router{party: r.party.Party(relativePath, middleware...), table: r.table}
+}
+
+// Routes returns the routes registered so far, including those of sub routers.
+func (r *Router) Routes() []RouteInfo {
+	r.table.mutex.RLock()
+	defer r.table.mutex.RUnlock()
+	return append([]RouteInfo(nil), r.table.routes...)
+}
+
+// Handle registers f for method and path using ControllerTemplateCtx and records the
+// Params type. It is a function because Go methods can't have type parameters.
+func Handle[Params any](r *Router, method, path string, f func(ctx context.Context, p Params) BusinessResult, opts ...TemplateOption) {
+	r.handle(method, path, func(ctx iris.Context) {
+		ControllerTemplateCtx(ctx, f, opts...)
+	}, reflect.TypeOf((*Params)(nil)).Elem(), nil, opts)
+}
+
+// HandleOf is Handle for business functions returning a TypedResult; it also records
+// the data type.
+func HandleOf[Params, T any](r *Router, method, path string, f func(ctx context.Context, p Params) TypedResult[T], opts ...TemplateOption) {
+	r.handle(method, path, func(ctx iris.Context) {
+		ControllerTemplateCtxOf(ctx, f, opts...)
+	}, reflect.TypeOf((*Params)(nil)).Elem(), reflect.TypeOf((*T)(nil)).Elem(), opts)
+}
+
+// handle panics when Params is a protobuf message value: messages must not be copied,
+// declare Params as a pointer to the message instead.
+func (r *Router) handle(method, path string, handler iris.Handler, params, data reflect.Type, opts []TemplateOption) {
+	if params.Kind() == reflect.Struct && reflect.PointerTo(params).Implements(protoMessageType) {
+		panic(fmt.Sprintf("request: %s %s: Params %v is a protobuf message value, use *%v", method, path, params, params))
+	}
+	route := r.party.Handle(method, path, handler)
+	options := newTemplateOptions(opts)
+
+	r.table.mutex.Lock()
+	defer r.table.mutex.Unlock()
+	r.table.routes = append(r.table.routes, RouteInfo{
+		Method:  method,
+		Path:    route.Tmpl().Src,
+		Summary: options.summary,
+		Tags:    options.tags,
+		Params:  params,
+		Data:    data,
+	})
+}
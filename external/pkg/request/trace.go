@@ -0,0 +1,27 @@
+package request
+
+import (
+	"github.com/bravpei/webtools/external/pkg/trace"
+	"github.com/kataras/iris/v12"
+)
+
+// startTrace reads the trace ID from traceparent / X-Request-Id or generates one,
+// stores it in the request context and echoes it in the X-Request-Id response header.
+func startTrace(ctx iris.Context) string {
+	r := ctx.Request()
+	traceID := trace.FromContext(r.Context())
+	if traceID == "" {
+		if traceID = trace.FromHeader(r.Header); traceID == "" {
+			traceID = trace.NewTraceID()
+		}
+		ctx.ResetRequest(r.WithContext(trace.WithTraceID(r.Context(), traceID)))
+	}
+	ctx.Header(trace.HeaderRequestID, traceID)
+	return traceID
+}
+
+// TraceID returns the trace ID of the request handled by ControllerTemplate.
+// Pass ctx.Request().Context() to slog.*Context or HttpClientWrapper to propagate it.
+func TraceID(ctx iris.Context) string {
+	return trace.FromContext(ctx.Request().Context())
+}
@@ -0,0 +1,81 @@
+package response
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ErrorCode is a business error code declared once with its default message,
+// HTTP status and result category.
+type ErrorCode struct {
+	Code       string
+	Message    string
+	HTTPStatus int
+	Status     Status
+}
+
+// Result builds a result for the code, using the default message when none is given.
+func (e ErrorCode) Result(message ...string) Result {
+	msg := e.Message
+	if len(message) > 0 && message[0] != "" {
+		msg = message[0]
+	}
+	return buildResult(e.Status, e.Code, msg)
+}
+
+// Built-in error codes used by the request templates
+var (
+	CodeParamParse    = Register(ErrorCode{Code: "PARAM_PARSE_ERROR", Message: "invalid parameters", HTTPStatus: http.StatusBadRequest, Status: StatusValidationError})
+	CodeParamValidate = Register(ErrorCode{Code: "PARAM_VALIDATE_ERROR", Message: "parameter validation failed", HTTPStatus: http.StatusBadRequest, Status: StatusValidationError})
+	CodeBusiness      = Register(ErrorCode{Code: "BUSINESS_ERROR", Message: "business error", HTTPStatus: http.StatusOK, Status: StatusFailure})
+	CodeInternal      = Register(ErrorCode{Code: "INTERNAL_ERROR", Message: "internal server error", HTTPStatus: http.StatusInternalServerError, Status: StatusServerError})
+	CodeTimeout       = Register(ErrorCode{Code: "REQUEST_TIMEOUT", Message: "request timed out", HTTPStatus: http.StatusGatewayTimeout, Status: StatusServerError})
+	CodeNotAcceptable = Register(ErrorCode{Code: "NOT_ACCEPTABLE", Message: "none of the accepted media types can be produced", HTTPStatus: http.StatusNotAcceptable, Status: StatusValidationError})
+	CodeInFlight      = Register(ErrorCode{Code: "IDEMPOTENCY_IN_FLIGHT", Message: "a request with the same idempotency key is in progress", HTTPStatus: http.StatusConflict, Status: StatusFailure})
+
+	CodeUnsupportedMediaType  = Register(ErrorCode{Code: "UNSUPPORTED_MEDIA_TYPE", Message: "unsupported request media type", HTTPStatus: http.StatusUnsupportedMediaType, Status: StatusValidationError})
+	CodeIdempotencyMismatch   = Register(ErrorCode{Code: "IDEMPOTENCY_KEY_REUSED", Message: "the idempotency key was used with a different request", HTTPStatus: http.StatusUnprocessableEntity, Status: StatusValidationError})
+	CodeIdempotencyKeyInvalid = Register(ErrorCode{Code: "IDEMPOTENCY_KEY_INVALID", Message: "the idempotency key is too long", HTTPStatus: http.StatusBadRequest, Status: StatusValidationError})
+	CodeIdempotencyStreamed   = Register(ErrorCode{Code: "IDEMPOTENCY_NOT_REPLAYABLE", Message: "the request was already completed and its response can't be replayed", HTTPStatus: http.StatusConflict, Status: StatusFailure})
+	CodeRequestTooLarge       = Register(ErrorCode{Code: "REQUEST_TOO_LARGE", Message: "the request body is too large", HTTPStatus: http.StatusRequestEntityTooLarge, Status: StatusValidationError})
+)
+
+var (
+	codesMu sync.RWMutex
+	codes   = map[string]ErrorCode{}
+)
+
+// Register declares an error code, usually at package initialization:
+//
+//	var ErrUserNotFound = response.Register(response.ErrorCode{
+//		Code: "USER_NOT_FOUND", Message: "user not found", HTTPStatus: http.StatusNotFound, Status: response.StatusFailure,
+//	})
+//
+// HTTPStatus defaults to 200. Registering the same code twice panics.
+func Register(code ErrorCode) ErrorCode {
+	if code.Code == "" {
+		panic("response: empty error code")
+	}
+	if code.HTTPStatus == 0 {
+		code.HTTPStatus = http.StatusOK
+	}
+	codesMu.Lock()
+	defer codesMu.Unlock()
+	if _, ok := codes[code.Code]; ok {
+		panic(fmt.Sprintf("response: error code %q registered twice", code.Code))
+	}
+	codes[code.Code] = code
+	return code
+}
+
+// Lookup returns a registered error code. Unregistered codes are reported as
+// failures with HTTP 200, which keeps free-form codes backward compatible.
+func Lookup(code string) (ErrorCode, bool) {
+	codesMu.RLock()
+	defer codesMu.RUnlock()
+	if e, ok := codes[code]; ok {
+		return e, true
+	}
+	return ErrorCode{Code: code, HTTPStatus: http.StatusOK, Status: StatusFailure}, false
+}
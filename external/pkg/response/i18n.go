@@ -0,0 +1,186 @@
+package response
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLocale is used when Accept-Language matches no registered catalog.
+var DefaultLocale = "en"
+
+// Catalog holds the translations of one locale.
+type Catalog struct {
+	// Messages maps error codes to messages; fmt verbs are filled from CodeError arguments.
+	Messages map[string]string
+	// Validators maps gookit/validate validator names to templates, e.g. "required": "{field} is required".
+	Validators map[string]string
+	// Fields maps parameter struct field names to display names.
+	Fields map[string]string
+}
+
+var (
+	catalogsMu sync.RWMutex
+	catalogs   = map[string]*Catalog{}
+)
+
+func init() {
+	RegisterCatalog("zh-CN", Catalog{Messages: map[string]string{
+		CodeParamParse.Code:    "参数解析失败",
+		CodeParamValidate.Code: "参数校验失败",
+		CodeInternal.Code:      "服务器内部错误",
+		CodeTimeout.Code:       "请求超时",
+		CodeInFlight.Code:      "相同幂等键的请求正在处理中",
+		CodeNotAcceptable.Code: "无法提供客户端可接受的响应格式",
+
+		CodeUnsupportedMediaType.Code:  "不支持的请求内容类型",
+		CodeIdempotencyMismatch.Code:   "幂等键已被用于其他请求",
+		CodeIdempotencyKeyInvalid.Code: "幂等键过长",
+		CodeIdempotencyStreamed.Code:   "相同幂等键的请求已完成，其响应无法重放",
+		CodeRequestTooLarge.Code:       "请求体过大",
+	}})
+}
+
+// RegisterCatalog adds translations for a locale such as "en" or "zh-CN",
+// merging them with any translations registered before.
+func RegisterCatalog(locale string, catalog Catalog) {
+	catalogsMu.Lock()
+	defer catalogsMu.Unlock()
+
+	key := strings.ToLower(locale)
+	c, ok := catalogs[key]
+	if !ok {
+		c = &Catalog{Messages: map[string]string{}, Validators: map[string]string{}, Fields: map[string]string{}}
+		catalogs[key] = c
+	}
+	for k, v := range catalog.Messages {
+		c.Messages[k] = v
+	}
+	for k, v := range catalog.Validators {
+		c.Validators[k] = v
+	}
+	for k, v := range catalog.Fields {
+		c.Fields[k] = v
+	}
+}
+
+// Negotiate picks the best registered locale from an Accept-Language header.
+// A language range also matches locales with the same primary language, so "zh" selects "zh-CN".
+func Negotiate(acceptLanguage string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{strings.ToLower(tag), q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	catalogsMu.RLock()
+	defer catalogsMu.RUnlock()
+	for _, t := range tags {
+		if _, ok := catalogs[t.tag]; ok {
+			return t.tag
+		}
+		primary, _, _ := strings.Cut(t.tag, "-")
+		if primary == strings.ToLower(DefaultLocale) {
+			return DefaultLocale
+		}
+		var candidates []string
+		for locale := range catalogs {
+			if p, _, _ := strings.Cut(locale, "-"); p == primary {
+				candidates = append(candidates, locale)
+			}
+		}
+		if len(candidates) > 0 {
+			sort.Strings(candidates)
+			return candidates[0]
+		}
+	}
+	return DefaultLocale
+}
+
+// Translate returns the message for an error code in the locale, falling back to DefaultLocale.
+func Translate(locale, code string, args ...any) (string, bool) {
+	catalogsMu.RLock()
+	defer catalogsMu.RUnlock()
+	for _, l := range []string{locale, DefaultLocale} {
+		if c, ok := catalogs[strings.ToLower(l)]; ok {
+			if msg, ok := c.Messages[code]; ok {
+				return format(msg, args), true
+			}
+		}
+	}
+	return "", false
+}
+
+// ValidatorMessages returns a copy of the validator message templates of the locale.
+func ValidatorMessages(locale string) map[string]string {
+	catalogsMu.RLock()
+	defer catalogsMu.RUnlock()
+	if c, ok := catalogs[strings.ToLower(locale)]; ok {
+		return maps.Clone(c.Validators)
+	}
+	return nil
+}
+
+// FieldNames returns a copy of the translated field display names of the locale.
+func FieldNames(locale string) map[string]string {
+	catalogsMu.RLock()
+	defer catalogsMu.RUnlock()
+	if c, ok := catalogs[strings.ToLower(locale)]; ok {
+		return maps.Clone(c.Fields)
+	}
+	return nil
+}
+
+// Localize returns the code's message in the locale, or its default message.
+func (e ErrorCode) Localize(locale string, args ...any) string {
+	if msg, ok := Translate(locale, e.Code, args...); ok {
+		return msg
+	}
+	return format(e.Message, args)
+}
+
+// CodeError is an error carrying a registered error code and its message arguments.
+type CodeError struct {
+	Code ErrorCode
+	Args []any
+}
+
+// Err creates an error for the code, args fill the fmt verbs of its messages.
+func (e ErrorCode) Err(args ...any) error {
+	return &CodeError{Code: e, Args: args}
+}
+
+func (e *CodeError) Error() string {
+	return format(e.Code.Message, e.Args)
+}
+
+// Localize returns the error message in the locale.
+func (e *CodeError) Localize(locale string) string {
+	return e.Code.Localize(locale, e.Args...)
+}
+
+func format(msg string, args []any) string {
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
@@ -2,11 +2,12 @@ package response
 
 type Status int
 
+// Result status categories
 const (
-	success Status = iota
-	failure
-	validationError
-	serverError
+	StatusSuccess Status = iota
+	StatusFailure
+	StatusValidationError
+	StatusServerError
 )
 
 type Result struct {
@@ -15,33 +16,44 @@ type Result struct {
 	Message string `json:"message,omitempty"`
 	Data    any    `json:"data,omitempty"`
 	TraceId string `json:"trace_id,omitempty"`
+	// Errors lists every failed field when the template is configured to report all field errors
+	Errors []FieldError `json:"errors,omitempty"`
+	// Details is the underlying error of a parameter parse failure, e.g. which field was malformed
+	Details string `json:"details,omitempty"`
+}
+
+// FieldError is a single parameter validation failure.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
 
 var statusMessages = map[Status]string{
-	success:         "success",
-	failure:         "failure",
-	validationError: "validationError",
-	serverError:     "serverError",
+	StatusSuccess:         "success",
+	StatusFailure:         "failure",
+	StatusValidationError: "validationError",
+	StatusServerError:     "serverError",
 }
 
 func Succeed(data any) Result {
 	return Result{
-		Status:  success,
-		Message: statusMessages[success],
+		Status:  StatusSuccess,
+		Message: statusMessages[StatusSuccess],
 		Data:    data,
 	}
 }
 
 func Fail(errCode string, message ...string) Result {
-	return buildResult(failure, errCode, message...)
+	return buildResult(StatusFailure, errCode, message...)
 }
 
 func ValidateError(errCode string, message ...string) Result {
-	return buildResult(validationError, errCode, message...)
+	return buildResult(StatusValidationError, errCode, message...)
 }
 
 func ServerError(errCode string, message ...string) Result {
-	return buildResult(serverError, errCode, message...)
+	return buildResult(StatusServerError, errCode, message...)
 }
 
 func buildResult(status Status, errCode string, message ...string) Result {
@@ -0,0 +1,107 @@
+// Package trace propagates a request trace ID through context.Context and HTTP headers.
+// Incoming IDs are read from the W3C traceparent header or X-Request-Id; outbound
+// requests carry both.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	// HeaderTraceparent is the W3C Trace Context header
+	HeaderTraceparent = "traceparent"
+	// HeaderRequestID is the conventional request ID header
+	HeaderRequestID = "X-Request-Id"
+
+	maxRequestIDLength = 128
+)
+
+type ctxKey struct{}
+
+// WithTraceID returns a copy of ctx carrying the trace ID.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, traceID)
+}
+
+// FromContext returns the trace ID stored in ctx, or an empty string.
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// NewTraceID generates a random W3C trace ID (32 lowercase hex characters).
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// NewSpanID generates a random W3C span ID (16 lowercase hex characters).
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ParseTraceparent parses a traceparent header of the form
+// "00-<trace-id>-<parent-id>-<flags>".
+func ParseTraceparent(value string) (traceID, parentID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false
+	}
+	traceID, parentID = strings.ToLower(parts[1]), strings.ToLower(parts[2])
+	if !isValidID(traceID, 32) || !isValidID(parentID, 16) {
+		return "", "", false
+	}
+	return traceID, parentID, true
+}
+
+// isValidID reports whether id is a non-zero lowercase hex string of the given length.
+func isValidID(id string, length int) bool {
+	if len(id) != length || strings.Trim(id, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// FromHeader extracts a trace ID from traceparent, falling back to X-Request-Id.
+// It returns an empty string when neither header holds a usable value.
+func FromHeader(header http.Header) string {
+	if traceID, _, ok := ParseTraceparent(header.Get(HeaderTraceparent)); ok {
+		return traceID
+	}
+	id := strings.TrimSpace(header.Get(HeaderRequestID))
+	if id == "" || len(id) > maxRequestIDLength {
+		return ""
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return ""
+		}
+	}
+	return id
+}
+
+// Inject writes the trace ID of ctx into outbound request headers. traceparent is
+// only set when the ID is a valid W3C trace ID, with a fresh span ID as parent.
+func Inject(ctx context.Context, header http.Header) {
+	traceID := FromContext(ctx)
+	if traceID == "" {
+		return
+	}
+	if isValidID(traceID, 32) {
+		header.Set(HeaderTraceparent, "00-"+traceID+"-"+NewSpanID()+"-01")
+	}
+	header.Set(HeaderRequestID, traceID)
+}
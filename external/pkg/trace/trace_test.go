@@ -0,0 +1,49 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestFromHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set(HeaderTraceparent, "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01")
+	h.Set(HeaderRequestID, "req-1")
+	if id := FromHeader(h); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("got %q", id)
+	}
+
+	h.Set(HeaderTraceparent, "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	if id := FromHeader(h); id != "req-1" {
+		t.Fatalf("invalid traceparent should fall back to X-Request-Id, got %q", id)
+	}
+
+	h.Set(HeaderRequestID, "bad id\n")
+	if id := FromHeader(h); id != "" {
+		t.Fatalf("got %q", id)
+	}
+}
+
+func TestInject(t *testing.T) {
+	traceID := NewTraceID()
+	h := http.Header{}
+	Inject(WithTraceID(context.Background(), traceID), h)
+	got, parent, ok := ParseTraceparent(h.Get(HeaderTraceparent))
+	if !ok || got != traceID || parent == "" || h.Get(HeaderRequestID) != traceID {
+		t.Fatalf("headers %v", h)
+	}
+
+	// Non-W3C request IDs are only forwarded as X-Request-Id
+	h = http.Header{}
+	Inject(WithTraceID(context.Background(), "req-1"), h)
+	if h.Get(HeaderTraceparent) != "" || h.Get(HeaderRequestID) != "req-1" {
+		t.Fatalf("headers %v", h)
+	}
+
+	h = http.Header{}
+	Inject(context.Background(), h)
+	if len(h) != 0 {
+		t.Fatalf("headers %v", h)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/gobwas/ws"
@@ -11,6 +12,7 @@ import (
 	"github.com/panjf2000/gnet/v2"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"sync"
@@ -19,14 +21,15 @@ import (
 )
 
 const (
-	websocketPrefix = "GET /"
-	maxMessageSize  = 1024 * 1024 // 1MB
+	maxMessageSize = 1024 * 1024 // 1MB
 )
 
 // GNetUtil 网络工具结构体
 type GNetUtil struct {
 	// 配置选项
 	config *GNetConfig
+	// 空闲检测与心跳共用的时间轮，两者均未配置时为 nil
+	wheel *TimingWheel
 }
 
 // GNetConfig 配置结构体
@@ -34,6 +37,26 @@ type GNetConfig struct {
 	MaxMessageSize   int64
 	HandshakeTimeout time.Duration
 	ReaderSize       int
+	ReadIdleTimeout  time.Duration
+	WriteIdleTimeout time.Duration
+	AllIdleTimeout   time.Duration
+	IdleHandler      IdleHandler
+	Heartbeat        time.Duration
+	Codec            Codec
+	FragmentSize     int
+	FragmentHandler  FragmentHandler
+	// PROXY protocol 与可信代理
+	ProxyProtocol         bool
+	ProxyProtocolRequired bool
+	TrustedProxies        []*net.IPNet
+	TLSConfig             *tls.Config
+	RateLimit             *RateLimit
+	// 普通 HTTP 请求的请求头与请求体上限
+	MaxHTTPHeaderSize int
+	MaxHTTPBodySize   int64
+	// 消息录制
+	Recorder     Recorder
+	RecordFilter RecordFilter
 }
 
 // GNetUtilOption 配置选项函数类型
@@ -46,7 +69,7 @@ func WithMaxMessageSize(size int64) GNetUtilOption {
 	}
 }
 
-// WithHandshakeTimeout 设置 WebSocket 握手超时时间
+// WithHandshakeTimeout 设置 WebSocket 握手超时时间；GNetServer 同样以此限制连接建立后识别出协议的时间
 func WithHandshakeTimeout(handshake time.Duration) GNetUtilOption {
 	return func(c *GNetConfig) {
 		c.HandshakeTimeout = handshake
@@ -59,43 +82,149 @@ func WithReaderSize(size int) GNetUtilOption {
 	}
 }
 
+// WithIdleTimeout 设置读空闲、写空闲、读写均空闲的超时时间，0 表示不检测
+func WithIdleTimeout(readIdle, writeIdle, allIdle time.Duration) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.ReadIdleTimeout = readIdle
+		c.WriteIdleTimeout = writeIdle
+		c.AllIdleTimeout = allIdle
+	}
+}
+
+// WithHeartbeat 设置 WebSocket 心跳间隔，每个间隔发送一次 ping，上一次 ping 未收到 pong 时关闭连接
+func WithHeartbeat(interval time.Duration) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.Heartbeat = interval
+	}
+}
+
+// WithCodec 设置 TCP 连接的编解码器，默认不分包
+func WithCodec(codec Codec) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.Codec = codec
+	}
+}
+
+// WithFragmentSize 设置 NextWriter 单个分片的最大负载长度
+func WithFragmentSize(size int) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.FragmentSize = size
+	}
+}
+
+// WithFragmentHandler 设置流式读取回调，设置后 WebSocket 数据帧逐帧交给回调，
+// HandleWsTraffic 的 handler 不再收到数据消息，MaxMessageSize 仅限制单帧大小
+func WithFragmentHandler(handler FragmentHandler) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.FragmentHandler = handler
+	}
+}
+
+// WithIdleHandler 设置空闲回调，未设置时空闲连接会被直接关闭
+func WithIdleHandler(handler IdleHandler) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.IdleHandler = handler
+	}
+}
+
 // NewGNetUtil 创建新的GNetUtil实例
 func NewGNetUtil(opts ...GNetUtilOption) *GNetUtil {
 	config := &GNetConfig{
-		MaxMessageSize:   maxMessageSize,
-		HandshakeTimeout: time.Second * 10,
-		ReaderSize:       4096,
+		MaxMessageSize:    maxMessageSize,
+		HandshakeTimeout:  time.Second * 10,
+		ReaderSize:        4096,
+		MaxHTTPHeaderSize: maxHTTPHeaderSize,
+		MaxHTTPBodySize:   maxHTTPBodySize,
+		Codec:             RawCodec{},
+		FragmentSize:      defaultFragmentSize,
 	}
 
 	for _, opt := range opts {
 		opt(config)
 	}
 
-	return &GNetUtil{config: config}
+	g := &GNetUtil{config: config}
+	if config.idleEnabled() || config.Heartbeat > 0 {
+		g.wheel = NewTimingWheelWithPool(1, idleWheelScale)
+		if err := g.wheel.Start(); err != nil {
+			slog.Error("start timing wheel failed", "error", err)
+			g.wheel = nil
+		}
+	}
+	return g
+}
+
+// Stop 释放 GNetUtil 持有的后台资源
+func (g *GNetUtil) Stop() {
+	if g.wheel != nil {
+		g.wheel.Stop()
+	}
 }
 
-// NewWsCtx 创建WebSocket上下文
+// NewWsCtx 创建WebSocket上下文，连接在首次流量时绑定
 func (g *GNetUtil) NewWsCtx() GnetContext {
-	return &WSContext{
-		config: g.config,
+	return g.newWsCtx(nil)
+}
+
+// NewWsCtxWithConn 创建WebSocket上下文并立即绑定连接，在 OnOpen 中调用时
+// 握手前的空闲检测即可关闭连接，远端地址也从建立连接起可用
+func (g *GNetUtil) NewWsCtxWithConn(c gnet.Conn) GnetContext {
+	return g.newWsCtx(c)
+}
+
+func (g *GNetUtil) newWsCtx(c gnet.Conn) *WSContext {
+	ctx := &WSContext{
+		config:  g.config,
+		limiter: newConnLimiter(g.config.RateLimit),
+		conn:    c,
+	}
+	ctx.initMeta(c)
+	if handler := g.config.FragmentHandler; handler != nil {
+		ctx.fr.stream = func(op ws.OpCode, fragment []byte, fin bool) {
+			ctx.recordFragment(op, fragment, fin)
+			handler(ctx, op, fragment, fin)
+		}
 	}
+	g.watchIdle(ctx)
+	return ctx
 }
 
 // NewTcpCtx 创建TCP上下文
 func (g *GNetUtil) NewTcpCtx(c gnet.Conn) GnetContext {
-	return &TCPContext{
+	ctx := &TCPContext{
 		config: g.config,
 		conn:   c,
 	}
+	ctx.initMeta(c)
+	g.watchIdle(ctx)
+	return ctx
+}
+
+// Release 在 OnClose 中调用，停止对该连接的空闲检测并释放 TLS 层
+func (g *GNetUtil) Release(ctx GnetContext) {
+	if h, ok := ctx.(metaHolder); ok {
+		h.meta().released.Store(true)
+	}
+	if t, ok := ctx.Conn().(*TLSConn); ok {
+		t.shutdown()
+	}
 }
 
-// IsWsConn 判断是否为WebSocket连接
+// ErrNeedMore 数据不足以判断协议，保留缓冲区等待下一次 OnTraffic 再判断
+var ErrNeedMore = errors.New("need more data")
+
+// IsWsConn 判断是否为WebSocket连接，仅带 Upgrade: websocket 的请求返回 true；
+// 请求头未收全时返回 ErrNeedMore，经 nginx 转发时握手请求可能分多个报文到达，
+// 调用方不应据此关闭连接或判定为 TCP
 func (g *GNetUtil) IsWsConn(c gnet.Conn) (bool, error) {
-	prefix, err := c.Peek(5)
+	protocol, err := g.DetectProtocol(c)
 	if err != nil {
-		return false, fmt.Errorf("peek connection failed: %v", err)
+		return false, err
+	}
+	if protocol == ProtocolUnknown {
+		return false, ErrNeedMore
 	}
-	return bytes.HasPrefix(prefix, []byte(websocketPrefix)), nil
+	return protocol == ProtocolWebSocket, nil
 }
 
 // GnetContext 网络上下文接口
@@ -103,11 +232,13 @@ type GnetContext interface {
 	GetType() string
 	Close() error
 	Write(data []byte) error
+	// Conn 底层 gnet 连接，不运行在事件循环上的上下文（WsClient、ReplayContext）返回 nil
 	Conn() gnet.Conn
 }
 
 // TCPContext TCP上下文实现
 type TCPContext struct {
+	connMeta
 	conn   gnet.Conn
 	config *GNetConfig
 	mutex  sync.Mutex
@@ -126,27 +257,74 @@ func (t *TCPContext) Conn() gnet.Conn {
 func (t *TCPContext) Write(data []byte) error {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	if t.config.Codec != nil {
+		var err error
+		if data, err = t.config.Codec.Encode(data); err != nil {
+			return err
+		}
+	}
 	_, err := t.conn.Write(data)
+	if err == nil {
+		t.touchWrite()
+	}
 	return err
 }
 
+// asyncWriter 可在事件循环外调用的写入，数据交给事件循环异步发送
+type asyncWriter interface {
+	asyncWrite(data []byte) error
+}
+
+// asyncWrite 编码后通过 AsyncWrite 发送，供总线等其它协程投递消息
+func (t *TCPContext) asyncWrite(data []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.config.Codec != nil {
+		var err error
+		if data, err = t.config.Codec.Encode(data); err != nil {
+			return err
+		}
+	}
+	return t.conn.AsyncWrite(data, func(_ gnet.Conn, err error) error {
+		if err == nil {
+			t.touchWrite()
+		}
+		return nil
+	})
+}
+
+// inboundBuffer 帧解析依赖的读缓冲区，gnet.Conn 与客户端缓冲区均实现该接口
+type inboundBuffer interface {
+	io.Reader
+	Peek(n int) ([]byte, error)
+	Discard(n int) (int, error)
+	InboundBuffered() int
+}
+
 // frameReader 管理 WebSocket 帧解析的中间状态
 type frameReader struct {
 	curHeader *ws.Header
 	cachedBuf bytes.Buffer
 	opCode    *ws.OpCode
+	// stream 非空时数据帧逐帧回调，不再缓存到 cachedBuf
+	stream func(op ws.OpCode, fragment []byte, fin bool)
 }
 
 // WSContext WebSocket上下文实现
 type WSContext struct {
+	connMeta
 	upgraded  bool
-	fr        frameReader  // 帧解析状态
+	fr        frameReader // 帧解析状态
 	config    *GNetConfig
-	conn      gnet.Conn
+	conn      gnet.Conn  // 由 connMutex 保护，时间轮等事件循环外的协程会读取
+	connMutex sync.Mutex // 不与写锁共用，未关闭的 NextWriter 不会阻塞 Conn 和 Close
 	pongState atomic.Bool
 	mutex     sync.Mutex
 	headers   http.Header // 存储HTTP Header
 	query     url.Values  // 存储Query参数
+	limiter   *connLimiter
+	stats     inboundCounter
+	stream    *streamRecord // 流式读取时正在录制的消息
 }
 
 func (w *WSContext) GetType() string {
@@ -154,11 +332,26 @@ func (w *WSContext) GetType() string {
 }
 
 func (w *WSContext) Close() error {
-	return w.conn.Close()
+	conn := w.Conn()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
 }
 func (w *WSContext) Conn() gnet.Conn {
+	w.connMutex.Lock()
+	defer w.connMutex.Unlock()
 	return w.conn
 }
+
+// bindConn 连接尚未绑定时绑定 c
+func (w *WSContext) bindConn(c gnet.Conn) {
+	w.connMutex.Lock()
+	defer w.connMutex.Unlock()
+	if w.conn == nil {
+		w.conn = c
+	}
+}
 func (w *WSContext) Write(data []byte) error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
@@ -167,7 +360,80 @@ func (w *WSContext) Write(data []byte) error {
 		return errors.New("connection not upgraded")
 	}
 
-	return wsutil.WriteServerText(w.conn, data)
+	if err := wsutil.WriteServerText(w.Conn(), data); err != nil {
+		return err
+	}
+	w.touchWrite()
+	w.record(RecordOut, ws.OpText, data)
+	return nil
+}
+
+// asyncWrite 将文本帧交给事件循环异步发送，供总线等其它协程投递消息
+func (w *WSContext) asyncWrite(data []byte) error {
+	return w.asyncWriteFrame(ws.NewTextFrame(data))
+}
+
+// asyncWriteFrame 将帧交给事件循环异步发送，可在时间轮等事件循环外的协程中调用。
+// 与 writeFrame 一致，控制帧不刷新最近写入时间
+func (w *WSContext) asyncWriteFrame(f ws.Frame) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.upgraded {
+		return errors.New("connection not upgraded")
+	}
+	frame, err := ws.CompileFrame(f)
+	if err != nil {
+		return err
+	}
+	if err = w.Conn().AsyncWrite(frame, func(_ gnet.Conn, err error) error {
+		if err == nil && !f.Header.OpCode.IsControl() {
+			w.touchWrite()
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	w.record(RecordOut, f.Header.OpCode, f.Payload)
+	return nil
+}
+
+// NextWriter 返回分片写入器，数据按 FragmentSize 切分为连续帧发送，Close 时发送结束帧。
+// 写入器关闭前会独占连接的写锁，期间其它 Write 调用会阻塞；开启录制时在关闭后录制完整消息
+func (w *WSContext) NextWriter(op ws.OpCode) (io.WriteCloser, error) {
+	w.mutex.Lock()
+	if !w.upgraded {
+		w.mutex.Unlock()
+		return nil, errors.New("connection not upgraded")
+	}
+	var tee *bytes.Buffer
+	if w.recording() {
+		tee = new(bytes.Buffer)
+	}
+	fw := newFragmentWriter(w.Conn(), op, w.config.FragmentSize, false, func() {
+		w.touchWrite()
+		if tee != nil {
+			w.emitRecord(RecordOut, op, tee.Bytes(), false)
+		}
+		w.mutex.Unlock()
+	})
+	fw.tee = tee
+	return fw, nil
+}
+
+// writeFrame 发送控制帧等原始帧
+func (w *WSContext) writeFrame(f ws.Frame) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.upgraded {
+		return errors.New("connection not upgraded")
+	}
+	if err := ws.WriteFrame(w.Conn(), f); err != nil {
+		return err
+	}
+	w.record(RecordOut, f.Header.OpCode, f.Payload)
+	return nil
 }
 
 // GetHeaders 获取HTTP Header
@@ -202,6 +468,7 @@ func (w *WSContext) upgrade(c gnet.Conn, fs ...func(ctx *WSContext) error) error
 		// 保存HTTP Header和Query参数
 		w.headers = req.Header
 		w.query = req.URL.Query()
+		w.applyForwarded(req.Header)
 		_, err = ws.Upgrade(c)
 		if err != nil {
 			done <- err
@@ -223,8 +490,11 @@ func (w *WSContext) upgrade(c gnet.Conn, fs ...func(ctx *WSContext) error) error
 			slog.Error("websocket upgrade failed", "error", err)
 			return err
 		}
+		// 写锁保护，Shutdown 等其它协程会读取升级状态
+		w.bindConn(c)
+		w.mutex.Lock()
 		w.upgraded = true
-		w.conn = c
+		w.mutex.Unlock()
 		return nil
 	case <-ctx.Done():
 		return errors.New("websocket upgrade timeout")
@@ -240,6 +510,7 @@ func (w *WSContext) read(c gnet.Conn) ([][]byte, error) {
 
 	var payloads [][]byte
 	for _, message := range messages {
+		w.record(RecordIn, message.OpCode, message.Payload)
 		if message.OpCode.IsControl() {
 			//心跳处理，如果有设置心跳
 			if message.OpCode == ws.OpPong {
@@ -254,6 +525,8 @@ func (w *WSContext) read(c gnet.Conn) ([][]byte, error) {
 		}
 
 		if message.OpCode == ws.OpText || message.OpCode == ws.OpBinary {
+			w.stats.messages.Add(1)
+			w.stats.bytes.Add(uint64(len(message.Payload)))
 			payloads = append(payloads, message.Payload)
 		}
 	}
@@ -267,9 +540,13 @@ func (g *GNetUtil) HandleWsTraffic(c gnet.Conn, handler func(message []byte), ht
 		return errors.New("invalid websocket context")
 	}
 
-	if c.InboundBuffered() <= 0 {
+	// 限流延迟期间由定时唤醒触发，此时可能没有新数据
+	if c.InboundBuffered() <= 0 && !ctx.delaying() {
 		return nil
 	}
+	ctx.bindConn(c)
+	ctx.bindAddr(c)
+	ctx.touchRead()
 
 	if !ctx.upgraded {
 		if err := ctx.upgrade(c, httpBusinessHandlers...); err != nil {
@@ -279,13 +556,65 @@ func (g *GNetUtil) HandleWsTraffic(c gnet.Conn, handler func(message []byte), ht
 			}
 			return err
 		}
+		g.startHeartbeat(ctx)
+	}
+
+	// 先投递积压的消息，积压清空后继续解析延迟期间到达的数据；
+	// 积压未清空时新数据留在缓冲区，超出积压上限后按溢出策略丢弃或断开
+	drop := false
+	if ctx.delaying() {
+		messages, err := ctx.admit(c, nil)
+		for _, message := range messages {
+			handler(message)
+		}
+		if err != nil || c.InboundBuffered() <= 0 {
+			return err
+		}
+		if ctx.delaying() {
+			if drop, err = ctx.delayOverflow(c.InboundBuffered()); err != nil || !drop {
+				return err
+			}
+		}
 	}
 
 	messages, err := ctx.read(c)
 	if err != nil {
+		if errors.Is(err, errMessageTooLarge) {
+			_ = ctx.writeFrame(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusMessageTooBig, "message too large")))
+		}
 		return err
 	}
+	if drop {
+		ctx.stats.dropped.Add(uint64(len(messages)))
+		return nil
+	}
+	messages, err = ctx.admit(c, messages)
+	for _, message := range messages {
+		handler(message)
+	}
+	return err
+}
+
+// HandleTcpTraffic 处理TCP流量，按配置的编解码器分包后交给 handler
+func (g *GNetUtil) HandleTcpTraffic(c gnet.Conn, handler func(data []byte)) error {
+	ctx, ok := c.Context().(*TCPContext)
+	if !ok {
+		return errors.New("invalid tcp context")
+	}
+
+	if c.InboundBuffered() <= 0 {
+		return nil
+	}
+	ctx.touchRead()
 
+	codec := g.config.Codec
+	if codec == nil {
+		codec = RawCodec{}
+	}
+	messages, err := codec.Decode(c)
+	if err != nil {
+		return err
+	}
 	for _, message := range messages {
 		handler(message)
 	}
@@ -293,7 +622,7 @@ func (g *GNetUtil) HandleWsTraffic(c gnet.Conn, handler func(message []byte), ht
 }
 
 // readFrame 读取WebSocket帧
-func (fr *frameReader) readFrame(c gnet.Conn, maxSize int64) ([]wsutil.Message, error) {
+func (fr *frameReader) readFrame(c inboundBuffer, maxSize int64) ([]wsutil.Message, error) {
 	var messages []wsutil.Message
 
 	for {
@@ -302,6 +631,14 @@ func (fr *frameReader) readFrame(c gnet.Conn, maxSize int64) ([]wsutil.Message,
 			if c.InboundBuffered() < ws.MinHeaderSize {
 				return messages, nil
 			}
+			// 帧头可能分多次到达，完整帧头到达前不解析，避免 ReadHeader 消费半个帧头
+			head, err := c.Peek(ws.MinHeaderSize)
+			if err != nil {
+				return nil, fmt.Errorf("peek header failed: %v", err)
+			}
+			if c.InboundBuffered() < headerSize(head) {
+				return messages, nil
+			}
 
 			header, err := ws.ReadHeader(c)
 			if err != nil {
@@ -313,22 +650,23 @@ func (fr *frameReader) readFrame(c gnet.Conn, maxSize int64) ([]wsutil.Message,
 
 			// 检查消息大小
 			if header.Length > maxSize {
-				return nil, fmt.Errorf("message too large: %d > %d", header.Length, maxSize)
+				return nil, fmt.Errorf("%w: %d > %d", errMessageTooLarge, header.Length, maxSize)
 			}
 
 			fr.curHeader = &header
-			if fr.opCode == nil {
+			// 控制帧可能穿插在分片消息之间，不参与消息类型的记录
+			if fr.opCode == nil && !header.OpCode.IsControl() {
 				fr.opCode = &header.OpCode
 			}
 		}
 
 		// 读取消息体
-		if fr.curHeader.Length > 0 {
-			dataLength := int(fr.curHeader.Length)
-			if c.InboundBuffered() < dataLength {
-				return messages, nil
-			}
-
+		dataLength := int(fr.curHeader.Length)
+		if c.InboundBuffered() < dataLength {
+			return messages, nil
+		}
+		var payload []byte
+		if dataLength > 0 {
 			peek, err := c.Peek(dataLength)
 			if err != nil {
 				if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
@@ -336,33 +674,52 @@ func (fr *frameReader) readFrame(c gnet.Conn, maxSize int64) ([]wsutil.Message,
 				}
 				return nil, fmt.Errorf("peek data failed: %v", err)
 			}
+			payload = peek
+		}
 
+		header := fr.curHeader
+		switch {
+		case header.OpCode.IsControl():
+			// 控制帧不分片，直接作为独立消息返回
+			messages = append(messages, wsutil.Message{
+				OpCode:  header.OpCode,
+				Payload: fr.unmask(payload),
+			})
+		case fr.stream != nil:
+			// 流式模式：逐帧交给回调，不在内存中拼接完整消息
+			fr.stream(*fr.opCode, fr.unmask(payload), header.Fin)
+			if header.Fin {
+				fr.opCode = nil
+			}
+		default:
 			// 解密消息
-			cipherReader := wsutil.NewCipherReader(bytes.NewReader(peek), fr.curHeader.Mask)
-			if _, err = io.CopyN(&fr.cachedBuf, cipherReader, fr.curHeader.Length); err != nil {
-				return nil, fmt.Errorf("decrypt message failed: %v", err)
+			start := fr.cachedBuf.Len()
+			fr.cachedBuf.Write(payload)
+			if header.Masked {
+				ws.Cipher(fr.cachedBuf.Bytes()[start:], header.Mask, 0)
 			}
 
 			// 检查累积消息大小，防止分帧消息绕过单帧大小限制
 			if int64(fr.cachedBuf.Len()) > maxSize {
-				return nil, fmt.Errorf("message too large after reassembly: %d > %d", fr.cachedBuf.Len(), maxSize)
+				return nil, fmt.Errorf("%w after reassembly: %d > %d", errMessageTooLarge, fr.cachedBuf.Len(), maxSize)
 			}
 
-			if _, err = c.Discard(dataLength); err != nil {
-				return nil, fmt.Errorf("discard data failed: %v", err)
+			// 处理完整消息
+			if header.Fin {
+				messages = append(messages, wsutil.Message{
+					OpCode:  *fr.opCode,
+					Payload: bytes.Clone(fr.cachedBuf.Bytes()), // cachedBuf 会被后续帧复用
+				})
+				fr.cachedBuf.Reset()
+				fr.opCode = nil
 			}
 		}
 
-		// 处理完整消息
-		if fr.curHeader.Fin {
-			messages = append(messages, wsutil.Message{
-				OpCode:  *fr.opCode,
-				Payload: fr.cachedBuf.Bytes(),
-			})
-			fr.cachedBuf.Reset()
-			fr.opCode = nil
+		if dataLength > 0 {
+			if _, err := c.Discard(dataLength); err != nil {
+				return nil, fmt.Errorf("discard data failed: %v", err)
+			}
 		}
-
 		fr.curHeader = nil
 
 		// 检查是否还有更多数据
@@ -373,3 +730,30 @@ func (fr *frameReader) readFrame(c gnet.Conn, maxSize int64) ([]wsutil.Message,
 
 	return messages, nil
 }
+
+// headerSize 根据帧头前两个字节计算完整帧头长度，2 到 14 字节
+func headerSize(head []byte) int {
+	size := ws.MinHeaderSize
+	switch head[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if head[1]&0x80 != 0 {
+		size += 4
+	}
+	return size
+}
+
+// unmask 复制并解密帧负载，返回的切片可以在回调外继续持有
+func (fr *frameReader) unmask(payload []byte) []byte {
+	if len(payload) == 0 {
+		return nil
+	}
+	data := bytes.Clone(payload)
+	if fr.curHeader.Masked {
+		ws.Cipher(data, fr.curHeader.Mask, 0)
+	}
+	return data
+}
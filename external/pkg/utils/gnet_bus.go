@@ -0,0 +1,399 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BusHandler 总线消息回调
+type BusHandler func(topic string, message []byte)
+
+// Bus 跨节点消息总线，按主题发布订阅
+type Bus interface {
+	// Publish 发布消息，所有节点上订阅该主题的回调都会收到
+	Publish(topic string, message []byte) error
+	// Subscribe 订阅主题，返回取消订阅函数
+	Subscribe(topic string, handler BusHandler) (unsubscribe func(), err error)
+	// Close 关闭总线
+	Close() error
+}
+
+// MemoryBus 进程内总线，单节点部署或测试使用，回调在 Publish 的协程中同步执行
+type MemoryBus struct {
+	mutex  sync.RWMutex
+	subs   map[string]map[uint64]BusHandler
+	seq    atomic.Uint64
+	closed atomic.Bool
+}
+
+// NewMemoryBus 创建进程内总线
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{subs: make(map[string]map[uint64]BusHandler)}
+}
+
+func (b *MemoryBus) Publish(topic string, message []byte) error {
+	if b.closed.Load() {
+		return errors.New("bus closed")
+	}
+	b.mutex.RLock()
+	handlers := make([]BusHandler, 0, len(b.subs[topic]))
+	for _, handler := range b.subs[topic] {
+		handlers = append(handlers, handler)
+	}
+	b.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(topic, message)
+	}
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(topic string, handler BusHandler) (func(), error) {
+	if b.closed.Load() {
+		return nil, errors.New("bus closed")
+	}
+	id := b.seq.Add(1)
+	b.mutex.Lock()
+	if b.subs[topic] == nil {
+		b.subs[topic] = make(map[uint64]BusHandler)
+	}
+	b.subs[topic][id] = handler
+	b.mutex.Unlock()
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subs[topic], id)
+		if len(b.subs[topic]) == 0 {
+			delete(b.subs, topic)
+		}
+	}, nil
+}
+
+func (b *MemoryBus) Close() error {
+	b.closed.Store(true)
+	return nil
+}
+
+const (
+	// busPeerQueueSize 每个对端待发送帧的队列长度，队列满时丢弃新消息
+	busPeerQueueSize = 1024
+	// busRedialInterval 连接失败后重连前的等待时间，期间到达的消息直接丢弃
+	busRedialInterval = time.Second
+	// defaultBusMaxFrameSize 默认单帧上限，包括主题与长度字段
+	defaultBusMaxFrameSize = 4 * 1024 * 1024
+)
+
+// errBusFrameTooLarge 帧长度超出上限，读取方在分配缓冲区前拒绝并断开对端
+var errBusFrameTooLarge = errors.New("bus frame too large")
+
+// busPeer 到其它节点的出站连接，由后台协程建连并按顺序发送队列中的帧，发布者不会因对端阻塞
+type busPeer struct {
+	addr   string
+	queue  chan []byte
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	mutex  sync.Mutex
+	conn   net.Conn
+}
+
+func newBusPeer(addr string) *busPeer {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &busPeer{
+		addr:   addr,
+		queue:  make(chan []byte, busPeerQueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// send 将帧放入发送队列，队列满或已关闭时丢弃并返回错误
+func (p *busPeer) send(frame []byte) error {
+	if p.ctx.Err() != nil {
+		return fmt.Errorf("bus peer %s closed", p.addr)
+	}
+	select {
+	case p.queue <- frame:
+		return nil
+	default:
+		return fmt.Errorf("bus peer %s queue full, message dropped", p.addr)
+	}
+}
+
+func (p *busPeer) run() {
+	defer close(p.done)
+	var retryAt time.Time
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case frame := <-p.queue:
+			if time.Now().Before(retryAt) {
+				continue
+			}
+			if err := p.write(frame); err != nil {
+				if p.ctx.Err() == nil {
+					slog.Debug("send to bus peer failed", "addr", p.addr, "error", err)
+				}
+				retryAt = time.Now().Add(busRedialInterval)
+			}
+		}
+	}
+}
+
+// write 发送一帧，连接可能已被对端关闭，失败后重连重试一次
+func (p *busPeer) write(frame []byte) error {
+	for i := 0; i < 2; i++ {
+		p.mutex.Lock()
+		conn := p.conn
+		p.mutex.Unlock()
+		if conn == nil {
+			dialer := net.Dialer{Timeout: time.Second * 3}
+			var err error
+			if conn, err = dialer.DialContext(p.ctx, "tcp", p.addr); err != nil {
+				return fmt.Errorf("dial bus peer %s failed: %v", p.addr, err)
+			}
+			p.mutex.Lock()
+			if p.ctx.Err() != nil {
+				p.mutex.Unlock()
+				_ = conn.Close()
+				return p.ctx.Err()
+			}
+			p.conn = conn
+			p.mutex.Unlock()
+		}
+		if _, err := conn.Write(frame); err == nil {
+			return nil
+		}
+		p.mutex.Lock()
+		_ = conn.Close()
+		p.conn = nil
+		p.mutex.Unlock()
+	}
+	return fmt.Errorf("send to bus peer %s failed", p.addr)
+}
+
+// close 停止发送协程，正在进行的连接和写入会被中断
+func (p *busPeer) close() {
+	p.cancel()
+	p.mutex.Lock()
+	if p.conn != nil {
+		_ = p.conn.Close()
+		p.conn = nil
+	}
+	p.mutex.Unlock()
+	<-p.done
+}
+
+// TCPBus 基于 TCP 全连接的总线：每个节点监听一个地址，发布时投递到本地订阅者并转发给所有对端节点。
+// 适合少量节点的集群或本地多进程测试
+type TCPBus struct {
+	local        *MemoryBus
+	listener     net.Listener
+	mutex        sync.Mutex
+	peers        map[string]*busPeer
+	inbound      map[net.Conn]struct{}
+	closed       atomic.Bool
+	wg           sync.WaitGroup
+	maxFrameSize atomic.Int64
+}
+
+// NewTCPBus 监听 listenAddr 并连接 peers，listenAddr 可使用 127.0.0.1:0 由系统分配端口
+func NewTCPBus(listenAddr string, peers ...string) (*TCPBus, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("listen bus failed: %v", err)
+	}
+	b := &TCPBus{
+		local:    NewMemoryBus(),
+		listener: listener,
+		peers:    make(map[string]*busPeer),
+		inbound:  make(map[net.Conn]struct{}),
+	}
+	b.maxFrameSize.Store(defaultBusMaxFrameSize)
+	for _, peer := range peers {
+		b.AddPeer(peer)
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// Addr 实际监听地址
+func (b *TCPBus) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// SetMaxFrameSize 设置单帧上限，默认 4MB。总线监听没有认证，对端声明的长度超出上限时
+// 在分配缓冲区前断开连接；发布超出上限的消息会返回错误。集群内各节点应使用相同的上限
+func (b *TCPBus) SetMaxFrameSize(size int64) {
+	b.maxFrameSize.Store(size)
+}
+
+// AddPeer 添加对端节点，连接在首次发布时由后台协程建立
+func (b *TCPBus) AddPeer(addr string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.closed.Load() {
+		return
+	}
+	if _, ok := b.peers[addr]; !ok {
+		b.peers[addr] = newBusPeer(addr)
+	}
+}
+
+// Publish 先投递本地订阅者，再放入各对端的发送队列，由后台协程转发；消息超出单帧上限时直接返回错误。
+// 返回队列已满而丢弃消息的节点错误，对端不可达时消息会被丢弃但不返回错误
+func (b *TCPBus) Publish(topic string, message []byte) error {
+	if b.closed.Load() {
+		return errors.New("bus closed")
+	}
+	// 超出上限的消息对端无法接收，本地也不投递，保证各节点行为一致
+	frame, err := encodeBusFrame(topic, message)
+	if err != nil {
+		return err
+	}
+	if int64(len(frame)) > b.maxFrameSize.Load() {
+		return errBusFrameTooLarge
+	}
+	if err = b.local.Publish(topic, message); err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	peers := make([]*busPeer, 0, len(b.peers))
+	for _, peer := range b.peers {
+		peers = append(peers, peer)
+	}
+	b.mutex.Unlock()
+
+	var errs []error
+	for _, peer := range peers {
+		if err = peer.send(frame); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *TCPBus) Subscribe(topic string, handler BusHandler) (func(), error) {
+	return b.local.Subscribe(topic, handler)
+}
+
+func (b *TCPBus) Close() error {
+	if !b.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	err := b.listener.Close()
+	b.mutex.Lock()
+	for _, peer := range b.peers {
+		peer.close()
+	}
+	for conn := range b.inbound {
+		_ = conn.Close()
+	}
+	b.mutex.Unlock()
+	b.wg.Wait()
+	_ = b.local.Close()
+	return err
+}
+
+func (b *TCPBus) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			if !b.closed.Load() {
+				slog.Error("accept bus connection failed", "error", err)
+			}
+			return
+		}
+		b.mutex.Lock()
+		b.inbound[conn] = struct{}{}
+		b.mutex.Unlock()
+		b.wg.Add(1)
+		go b.serve(conn)
+	}
+}
+
+// serve 读取对端转发的消息，只投递本地订阅者，不再继续转发
+func (b *TCPBus) serve(conn net.Conn) {
+	defer b.wg.Done()
+	defer func() {
+		b.mutex.Lock()
+		delete(b.inbound, conn)
+		b.mutex.Unlock()
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		topic, message, err := decodeBusFrame(reader, b.maxFrameSize.Load())
+		if err != nil {
+			if errors.Is(err, errBusFrameTooLarge) {
+				slog.Warn("bus frame too large, closing peer", "remote", conn.RemoteAddr())
+			} else if !errors.Is(err, io.EOF) && !b.closed.Load() {
+				slog.Debug("read bus frame failed", "remote", conn.RemoteAddr(), "error", err)
+			}
+			return
+		}
+		if err = b.local.Publish(topic, message); err != nil {
+			return
+		}
+	}
+}
+
+// encodeBusFrame 帧格式：2 字节主题长度 + 主题 + 4 字节消息长度 + 消息
+func encodeBusFrame(topic string, message []byte) ([]byte, error) {
+	if len(topic) > math.MaxUint16 {
+		return nil, errors.New("bus topic too long")
+	}
+	frame := make([]byte, 2+len(topic)+4+len(message))
+	binary.BigEndian.PutUint16(frame, uint16(len(topic)))
+	copy(frame[2:], topic)
+	binary.BigEndian.PutUint32(frame[2+len(topic):], uint32(len(message)))
+	copy(frame[6+len(topic):], message)
+	return frame, nil
+}
+
+// decodeBusFrame 读取一帧，长度字段在分配缓冲区前按 maxSize 校验
+func decodeBusFrame(r io.Reader, maxSize int64) (string, []byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:2]); err != nil {
+		return "", nil, err
+	}
+	topicLen := int64(binary.BigEndian.Uint16(head[:2]))
+	if 6+topicLen > maxSize {
+		return "", nil, errBusFrameTooLarge
+	}
+	topic := make([]byte, topicLen)
+	if _, err := io.ReadFull(r, topic); err != nil {
+		return "", nil, err
+	}
+	if _, err := io.ReadFull(r, head[:4]); err != nil {
+		return "", nil, err
+	}
+	messageLen := int64(binary.BigEndian.Uint32(head[:4]))
+	if 6+topicLen+messageLen > maxSize {
+		return "", nil, errBusFrameTooLarge
+	}
+	message := make([]byte, messageLen)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return "", nil, err
+	}
+	return string(topic), message, nil
+}
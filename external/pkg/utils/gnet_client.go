@@ -0,0 +1,418 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/panjf2000/gnet/v2"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// wsClientConfig WebSocket 客户端配置
+type wsClientConfig struct {
+	maxMessageSize    int64
+	readBufferSize    int
+	heartbeat         time.Duration
+	reconnect         bool
+	reconnectMinDelay time.Duration
+	reconnectMaxDelay time.Duration
+	fragmentSize      int
+	fragmentHandler   FragmentHandler
+	handler           func(ctx GnetContext, message []byte)
+	onConnect         func(ctx GnetContext)
+}
+
+// WsClientOption 客户端配置选项
+type WsClientOption func(*wsClientConfig)
+
+// WithClientHandler 设置消息回调，在客户端读协程中执行
+func WithClientHandler(handler func(ctx GnetContext, message []byte)) WsClientOption {
+	return func(c *wsClientConfig) {
+		c.handler = handler
+	}
+}
+
+// WithClientOnConnect 设置连接成功回调，首次连接与每次重连成功后均会触发
+func WithClientOnConnect(f func(ctx GnetContext)) WsClientOption {
+	return func(c *wsClientConfig) {
+		c.onConnect = f
+	}
+}
+
+// WithClientHeartbeat 设置心跳间隔，上一次 ping 未收到 pong 时断开连接（开启重连时会自动重连）
+func WithClientHeartbeat(interval time.Duration) WsClientOption {
+	return func(c *wsClientConfig) {
+		c.heartbeat = interval
+	}
+}
+
+// minReconnectDelay 重连间隔下限，避免 minDelay 为 0 时无间隔地重试
+const minReconnectDelay = 100 * time.Millisecond
+
+// WithClientReconnect 开启断线重连，重连间隔从 minDelay 开始指数退避，最大不超过 maxDelay，
+// 每次等待会加入随机抖动；minDelay 小于 100ms 时按 100ms 处理
+func WithClientReconnect(minDelay, maxDelay time.Duration) WsClientOption {
+	return func(c *wsClientConfig) {
+		c.reconnect = true
+		c.reconnectMinDelay = max(minDelay, minReconnectDelay)
+		c.reconnectMaxDelay = max(maxDelay, c.reconnectMinDelay)
+	}
+}
+
+// WithClientMaxMessageSize 设置最大消息大小
+func WithClientMaxMessageSize(size int64) WsClientOption {
+	return func(c *wsClientConfig) {
+		c.maxMessageSize = size
+	}
+}
+
+// WithClientFragmentSize 设置 NextWriter 单个分片的最大负载长度
+func WithClientFragmentSize(size int) WsClientOption {
+	return func(c *wsClientConfig) {
+		c.fragmentSize = size
+	}
+}
+
+// WithClientFragmentHandler 设置流式读取回调，语义与 WithFragmentHandler 相同
+func WithClientFragmentHandler(handler FragmentHandler) WsClientOption {
+	return func(c *wsClientConfig) {
+		c.fragmentHandler = handler
+	}
+}
+
+// clientBuffer 客户端读缓冲区，为 frameReader 提供与 gnet.Conn 一致的读接口
+type clientBuffer struct {
+	data []byte
+	off  int
+}
+
+func (b *clientBuffer) Read(p []byte) (int, error) {
+	if b.off >= len(b.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[b.off:])
+	b.off += n
+	return n, nil
+}
+
+func (b *clientBuffer) Peek(n int) ([]byte, error) {
+	if n > b.InboundBuffered() {
+		return nil, io.ErrShortBuffer
+	}
+	if n <= 0 {
+		n = b.InboundBuffered()
+	}
+	return b.data[b.off : b.off+n], nil
+}
+
+func (b *clientBuffer) Discard(n int) (int, error) {
+	n = min(n, b.InboundBuffered())
+	b.off += n
+	return n, nil
+}
+
+func (b *clientBuffer) InboundBuffered() int {
+	return len(b.data) - b.off
+}
+
+// write 追加数据，缓冲区读空时复用底层数组
+func (b *clientBuffer) write(p []byte) {
+	if b.off >= len(b.data) {
+		b.data = b.data[:0]
+		b.off = 0
+	}
+	b.data = append(b.data, p...)
+}
+
+// WsClient WebSocket 客户端，与服务端共用帧解析逻辑，实现 GnetContext 接口
+type WsClient struct {
+	connMeta
+	url       string
+	headers   http.Header
+	config    *wsClientConfig
+	conn      net.Conn   // 当前连接，断开期间为 nil，由 connMutex 保护
+	connMutex sync.Mutex // 不与写锁共用，Close 不会被未关闭的 NextWriter 阻塞
+	mutex     sync.Mutex // 写锁，保证帧按顺序完整写出
+	fr        frameReader
+	buf       clientBuffer
+	pongState atomic.Bool
+	closed    atomic.Bool
+	done      chan struct{}
+}
+
+// DialWs 连接 WebSocket 服务端，首次连接失败直接返回错误，之后的断线按配置重连
+func DialWs(ctx context.Context, url string, headers http.Header, opts ...WsClientOption) (*WsClient, error) {
+	config := &wsClientConfig{
+		maxMessageSize:    maxMessageSize,
+		readBufferSize:    4096,
+		reconnectMinDelay: time.Second,
+		reconnectMaxDelay: time.Second * 30,
+		fragmentSize:      defaultFragmentSize,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	client := &WsClient{
+		url:     url,
+		headers: headers,
+		config:  config,
+		done:    make(chan struct{}),
+	}
+	client.initMeta(nil)
+	conn, err := client.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go client.run(conn)
+	if config.heartbeat > 0 {
+		go client.keepalive()
+	}
+	return client, nil
+}
+
+func (w *WsClient) GetType() string {
+	return "ws"
+}
+
+// Conn 客户端不运行在 gnet 事件循环上，返回 nil，与 ReplayContext 一致；
+// 依赖 gnet.Conn 的服务端辅助方法对 nil 连接不做处理，底层连接使用 NetConn 获取
+func (w *WsClient) Conn() gnet.Conn {
+	return nil
+}
+
+// NetConn 返回当前连接，断开期间为 nil，重连后返回新连接。
+// 可用于读取地址或设置套接字参数，写入请使用 Write 或 NextWriter，避免破坏分帧
+func (w *WsClient) NetConn() net.Conn {
+	return w.currentConn()
+}
+
+// Close 关闭客户端并停止重连。写锁空闲时先发送关闭帧；
+// 写锁被未关闭的 NextWriter 占用时直接关闭连接，写入器随后返回错误
+func (w *WsClient) Close() error {
+	if !w.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(w.done)
+	conn := w.currentConn()
+	if conn == nil {
+		return nil
+	}
+	if w.mutex.TryLock() {
+		_ = conn.SetWriteDeadline(time.Now().Add(time.Second))
+		_ = ws.WriteFrame(conn, ws.MaskFrameInPlace(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusNormalClosure, ""))))
+		w.mutex.Unlock()
+	}
+	return conn.Close()
+}
+
+// currentConn 返回当前连接，断开期间为 nil
+func (w *WsClient) currentConn() net.Conn {
+	w.connMutex.Lock()
+	defer w.connMutex.Unlock()
+	return w.conn
+}
+
+func (w *WsClient) Write(data []byte) error {
+	return w.writeMessage(ws.OpText, data)
+}
+
+// NextWriter 返回分片写入器，帧负载会按客户端要求加掩码，Close 前独占连接的写锁
+func (w *WsClient) NextWriter(op ws.OpCode) (io.WriteCloser, error) {
+	w.mutex.Lock()
+	conn := w.currentConn()
+	if conn == nil {
+		w.mutex.Unlock()
+		return nil, errors.New("connection not established")
+	}
+	return newFragmentWriter(conn, op, w.config.fragmentSize, true, func() {
+		w.touchWrite()
+		w.mutex.Unlock()
+	}), nil
+}
+
+// writeMessage 发送掩码后的消息
+func (w *WsClient) writeMessage(op ws.OpCode, data []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	conn := w.currentConn()
+	if conn == nil {
+		return errors.New("connection not established")
+	}
+	if err := wsutil.WriteClientMessage(conn, op, data); err != nil {
+		return err
+	}
+	w.touchWrite()
+	return nil
+}
+
+// dial 握手并登记新连接，握手时预读的数据会先放入读缓冲区
+func (w *WsClient) dial(ctx context.Context) (net.Conn, error) {
+	dialer := ws.Dialer{Header: ws.HandshakeHeaderHTTP(w.headers)}
+	conn, br, _, err := dialer.Dial(ctx, w.url)
+	if err != nil {
+		return nil, fmt.Errorf("dial websocket failed: %v", err)
+	}
+
+	w.fr = frameReader{}
+	if handler := w.config.fragmentHandler; handler != nil {
+		w.fr.stream = func(op ws.OpCode, fragment []byte, fin bool) {
+			handler(w, op, fragment, fin)
+		}
+	}
+	w.buf = clientBuffer{}
+	if br != nil {
+		if n := br.Buffered(); n > 0 {
+			peek, _ := br.Peek(n)
+			w.buf.write(peek)
+		}
+		ws.PutReader(br)
+	}
+
+	w.connMutex.Lock()
+	w.conn = conn
+	w.connMutex.Unlock()
+	w.remoteAddr.Store(conn.RemoteAddr())
+	w.pongState.Store(true)
+	w.touchRead()
+	slog.Debug("websocket client connected", "url", w.url)
+
+	if w.config.onConnect != nil {
+		w.config.onConnect(w)
+	}
+	return conn, nil
+}
+
+// run 读循环，连接断开后按配置重连
+func (w *WsClient) run(conn net.Conn) {
+	for {
+		err := w.readLoop(conn)
+		w.connMutex.Lock()
+		if w.conn == conn {
+			w.conn = nil
+		}
+		w.connMutex.Unlock()
+		_ = conn.Close()
+
+		if w.closed.Load() {
+			return
+		}
+		slog.Debug("websocket client disconnected", "url", w.url, "error", err)
+		if !w.config.reconnect {
+			_ = w.Close()
+			return
+		}
+		if conn = w.redial(); conn == nil {
+			return
+		}
+	}
+}
+
+// redial 指数退避重连，客户端关闭时返回 nil
+func (w *WsClient) redial() net.Conn {
+	delay := w.config.reconnectMinDelay
+	for {
+		select {
+		case <-w.done:
+			return nil
+		case <-time.After(jitter(delay)):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		conn, err := w.dial(ctx)
+		cancel()
+		if err == nil {
+			return conn
+		}
+		slog.Debug("websocket client reconnect failed", "url", w.url, "delay", delay, "error", err)
+		delay = min(delay*2, w.config.reconnectMaxDelay)
+	}
+}
+
+// jitter 在 [d/2, d] 内随机取值，避免大量客户端在服务端恢复后同时重连
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+func (w *WsClient) readLoop(conn net.Conn) error {
+	chunk := make([]byte, w.config.readBufferSize)
+	for {
+		if w.buf.InboundBuffered() > 0 {
+			if err := w.handleFrames(); err != nil {
+				return err
+			}
+		}
+		n, err := conn.Read(chunk)
+		if n > 0 {
+			w.buf.write(chunk[:n])
+			w.touchRead()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleFrames 解析缓冲区中的完整消息，服务端下发的帧不带掩码
+func (w *WsClient) handleFrames() error {
+	messages, err := w.fr.readFrame(&w.buf, w.config.maxMessageSize)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		switch message.OpCode {
+		case ws.OpPing:
+			if err = w.writeMessage(ws.OpPong, message.Payload); err != nil {
+				return err
+			}
+		case ws.OpPong:
+			w.pongState.Store(true)
+		case ws.OpClose:
+			_ = w.writeMessage(ws.OpClose, message.Payload)
+			return errors.New("connection closed by server")
+		case ws.OpText, ws.OpBinary:
+			if w.config.handler != nil {
+				w.config.handler(w, message.Payload)
+			}
+		}
+	}
+	return nil
+}
+
+// keepalive 客户端心跳，单个客户端只有一条连接，使用独立协程
+func (w *WsClient) keepalive() {
+	ticker := time.NewTicker(w.config.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		conn := w.currentConn()
+		if conn == nil {
+			continue
+		}
+		if !w.pongState.Load() {
+			slog.Debug("websocket client heartbeat timeout", "url", w.url)
+			_ = conn.Close()
+			continue
+		}
+		w.pongState.Store(false)
+		if err := w.writeMessage(ws.OpPing, nil); err != nil {
+			_ = conn.Close()
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"github.com/gobwas/ws"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDialWs(t *testing.T) {
+	_, addr := startTestServer(t, WithOnMessage(func(ctx GnetContext, message []byte) {
+		_ = ctx.Write(message)
+	}))
+
+	received := make(chan string, 1)
+	client, err := DialWs(context.Background(), "ws://"+addr+"/", nil,
+		WithClientHeartbeat(time.Second),
+		WithClientHandler(func(ctx GnetContext, message []byte) {
+			received <- string(message)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err = client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "ping" {
+			t.Fatalf("got %q", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for echo")
+	}
+	if client.RemoteAddr() == nil {
+		t.Fatal("remote address not recorded")
+	}
+}
+
+func TestWsFragments(t *testing.T) {
+	util := NewGNetUtil(WithFragmentSize(4), WithFragmentHandler(func(ctx GnetContext, op ws.OpCode, fragment []byte, fin bool) {
+		writer, err := ctx.(*WSContext).NextWriter(op)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _ = writer.Write(fragment)
+		_ = writer.Close()
+	}))
+	_, addr := startTestServer(t, WithGNetUtil(util))
+
+	received := make(chan string, 1)
+	client, err := DialWs(context.Background(), "ws://"+addr+"/", nil,
+		WithClientFragmentSize(3),
+		WithClientHandler(func(ctx GnetContext, message []byte) {
+			received <- string(message)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	writer, err := client.NextWriter(ws.OpText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(writer, "hello ")
+	_, _ = io.WriteString(writer, "world")
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务端逐帧回显为独立消息，每条消息再按 4 字节分片，客户端重新拼装
+	var got []string
+	for len(strings.Join(got, "")) < len("hello world") {
+		select {
+		case msg := <-received:
+			got = append(got, msg)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout, got %q", got)
+		}
+	}
+	if strings.Join(got, "") != "hello world" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestFrameReaderPartialHeader(t *testing.T) {
+	payload := bytes.Repeat([]byte("a"), 200)
+	var frame bytes.Buffer
+	if err := ws.WriteFrame(&frame, ws.MaskFrame(ws.NewTextFrame(payload))); err != nil {
+		t.Fatal(err)
+	}
+	data := frame.Bytes()
+
+	// 2 字节基础头 + 2 字节扩展长度 + 4 字节掩码，逐字节送入帧头
+	var fr frameReader
+	var buf clientBuffer
+	for i := 0; i < 8; i++ {
+		buf.write(data[i : i+1])
+		messages, err := fr.readFrame(&buf, 1024)
+		if err != nil || len(messages) != 0 {
+			t.Fatalf("byte %d: messages %d, err %v", i, len(messages), err)
+		}
+	}
+	buf.write(data[8:])
+	messages, err := fr.readFrame(&buf, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || !bytes.Equal(messages[0].Payload, payload) {
+		t.Fatalf("got %d messages", len(messages))
+	}
+}
+
+func TestWsClientCloseWithOpenWriter(t *testing.T) {
+	_, addr := startTestServer(t)
+	client, err := DialWs(context.Background(), "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.NextWriter(ws.OpText); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Close blocked by unclosed writer")
+	}
+}
+
+func TestWsClientConn(t *testing.T) {
+	_, addr := startTestServer(t)
+	client, err := DialWs(context.Background(), "ws://"+addr+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if client.Conn() != nil {
+		t.Fatal("client exposes a gnet connection")
+	}
+	conn := client.NetConn()
+	if conn == nil || conn.RemoteAddr().String() != client.RemoteAddr().String() {
+		t.Fatalf("net conn %v", conn)
+	}
+	// 服务端辅助方法对客户端不做处理
+	if _, ok := TLSState(client); ok {
+		t.Fatal("client reported tls state")
+	}
+	onLoop(client, func() { t.Error("client ran an event loop callback") })
+	NewGNetUtil().Release(client)
+}
+
+func TestWsClientReconnectZeroDelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// 首个连接完成握手后立即断开，之后的连接直接关闭，让客户端持续重连
+	var attempts atomic.Int32
+	go func() {
+		for first := true; ; first = false {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if first {
+				_, _ = ws.Upgrade(conn)
+			} else {
+				attempts.Add(1)
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	client, err := DialWs(context.Background(), "ws://"+ln.Addr().String()+"/", nil, WithClientReconnect(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	// 下限 100ms 加抖动，500ms 内最多重连 10 次左右
+	if n := attempts.Load(); n == 0 || n > 12 {
+		t.Fatalf("%d reconnect attempts in 500ms", n)
+	}
+}
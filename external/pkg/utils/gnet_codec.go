@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/panjf2000/gnet/v2"
+)
+
+const lengthFieldSize = 4
+
+// Codec TCP 编解码器
+type Codec interface {
+	// Decode 从连接缓冲区中解出所有完整消息，数据不足时保留在缓冲区等待下次流量
+	Decode(c gnet.Conn) ([][]byte, error)
+	// Encode 为待发送的数据添加协议头
+	Encode(data []byte) ([]byte, error)
+}
+
+// RawCodec 不做分包，缓冲区中的数据原样交给业务
+type RawCodec struct{}
+
+func (RawCodec) Decode(c gnet.Conn) ([][]byte, error) {
+	if c.InboundBuffered() <= 0 {
+		return nil, nil
+	}
+	data, err := c.Next(-1)
+	if err != nil {
+		return nil, fmt.Errorf("read tcp data failed: %v", err)
+	}
+	return [][]byte{append([]byte(nil), data...)}, nil
+}
+
+func (RawCodec) Encode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// LengthFieldCodec 4 字节大端长度头 + 消息体
+type LengthFieldCodec struct {
+	// MaxFrameLength 单个消息体最大长度，0 表示不限制
+	MaxFrameLength int
+}
+
+func (l LengthFieldCodec) Decode(c gnet.Conn) ([][]byte, error) {
+	var messages [][]byte
+	for c.InboundBuffered() >= lengthFieldSize {
+		header, err := c.Peek(lengthFieldSize)
+		if err != nil {
+			return nil, fmt.Errorf("peek length field failed: %v", err)
+		}
+		length := int(binary.BigEndian.Uint32(header))
+		if l.MaxFrameLength > 0 && length > l.MaxFrameLength {
+			return nil, fmt.Errorf("frame too large: %d > %d", length, l.MaxFrameLength)
+		}
+		if c.InboundBuffered() < lengthFieldSize+length {
+			break
+		}
+		frame, err := c.Next(lengthFieldSize + length)
+		if err != nil {
+			return nil, fmt.Errorf("read frame failed: %v", err)
+		}
+		messages = append(messages, append([]byte(nil), frame[lengthFieldSize:]...))
+	}
+	return messages, nil
+}
+
+func (l LengthFieldCodec) Encode(data []byte) ([]byte, error) {
+	if l.MaxFrameLength > 0 && len(data) > l.MaxFrameLength {
+		return nil, errors.New("frame too large")
+	}
+	buf := make([]byte, lengthFieldSize+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[lengthFieldSize:], data)
+	return buf, nil
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gobwas/ws"
+	"io"
+)
+
+const defaultFragmentSize = 32 * 1024 // 32KB
+
+// FragmentHandler 流式读取回调，op 为消息类型（文本或二进制），fin 表示消息最后一个分片
+type FragmentHandler func(ctx GnetContext, op ws.OpCode, fragment []byte, fin bool)
+
+// fragmentWriter 将写入的数据切分为 WebSocket 分片帧
+type fragmentWriter struct {
+	w       io.Writer
+	op      ws.OpCode
+	size    int
+	masked  bool
+	buf     []byte
+	err     error
+	closed  bool
+	release func()
+	// tee 非空时记录已发出的负载，用于录制完整消息
+	tee *bytes.Buffer
+}
+
+func newFragmentWriter(w io.Writer, op ws.OpCode, size int, masked bool, release func()) *fragmentWriter {
+	if size <= 0 {
+		size = defaultFragmentSize
+	}
+	return &fragmentWriter{
+		w:       w,
+		op:      op,
+		size:    size,
+		masked:  masked,
+		buf:     make([]byte, 0, size),
+		release: release,
+	}
+}
+
+// Write 缓冲数据，超过分片大小时发送非结束帧；最后一段留到 Close 时作为结束帧发送
+func (f *fragmentWriter) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, errors.New("writer closed")
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.buf = append(f.buf, p...)
+	for len(f.buf) > f.size {
+		if err := f.flush(f.size, false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close 发送结束帧并释放连接写锁
+func (f *fragmentWriter) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	defer f.release()
+	if f.err != nil {
+		return f.err
+	}
+	return f.flush(len(f.buf), true)
+}
+
+func (f *fragmentWriter) flush(n int, fin bool) error {
+	frame := ws.NewFrame(f.op, fin, f.buf[:n])
+	if f.masked {
+		frame = ws.MaskFrameInPlace(frame)
+	}
+	if err := ws.WriteFrame(f.w, frame); err != nil {
+		f.err = err
+		return err
+	}
+	if f.tee != nil {
+		f.tee.Write(f.buf[:n])
+	}
+	// 首帧之后均为延续帧
+	f.op = ws.OpContinuation
+	f.buf = append(f.buf[:0], f.buf[n:]...)
+	return nil
+}
@@ -0,0 +1,314 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/panjf2000/gnet/v2"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxHTTPHeaderSize = 64 * 1024       // 64KB
+	maxHTTPBodySize   = 4 * 1024 * 1024 // 4MB
+)
+
+// WithHttpLimits 设置普通 HTTP 请求的请求头与请求体上限，超出时分别返回 431 和 413 并关闭连接
+func WithHttpLimits(maxHeaderSize int, maxBodySize int64) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.MaxHTTPHeaderSize = maxHeaderSize
+		c.MaxHTTPBodySize = maxBodySize
+	}
+}
+
+// httpMethods 用于识别 HTTP/1.x 请求行
+var httpMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodHead,
+	http.MethodOptions, http.MethodPatch, http.MethodConnect, http.MethodTrace,
+}
+
+// Protocol 连接协议类型
+type Protocol int8
+
+const (
+	// ProtocolUnknown 数据不足，暂时无法判断，需等待更多数据
+	ProtocolUnknown Protocol = iota
+	ProtocolTCP
+	ProtocolWebSocket
+	ProtocolHTTP
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolTCP:
+		return "tcp"
+	case ProtocolWebSocket:
+		return "ws"
+	case ProtocolHTTP:
+		return "http"
+	default:
+		return "unknown"
+	}
+}
+
+// DetectProtocol 根据首包识别协议：请求行不是 HTTP 方法时为 TCP，
+// 请求头带 Upgrade: websocket 时为 WebSocket，其余为普通 HTTP 请求
+func (g *GNetUtil) DetectProtocol(c gnet.Conn) (Protocol, error) {
+	buffered := c.InboundBuffered()
+	if buffered <= 0 {
+		return ProtocolUnknown, nil
+	}
+	peek, err := c.Peek(buffered)
+	if err != nil {
+		return ProtocolUnknown, fmt.Errorf("peek connection failed: %v", err)
+	}
+
+	isHTTP := false
+	for _, method := range httpMethods {
+		prefix := method + " "
+		if len(peek) < len(prefix) && strings.HasPrefix(prefix, string(peek)) {
+			return ProtocolUnknown, nil
+		}
+		if bytes.HasPrefix(peek, []byte(prefix)) {
+			isHTTP = true
+			break
+		}
+	}
+	if !isHTTP {
+		return ProtocolTCP, nil
+	}
+
+	end := bytes.Index(peek, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(peek) > g.config.MaxHTTPHeaderSize {
+			// 与 HandleHttpTraffic 一致，关闭前回复 431
+			_, _ = c.Write(statusResponse(http.StatusRequestHeaderFieldsTooLarge))
+			return ProtocolUnknown, errors.New("http header too large")
+		}
+		return ProtocolUnknown, nil
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(peek[:end+4])))
+	if err != nil {
+		return ProtocolUnknown, fmt.Errorf("parse http request failed: %v", err)
+	}
+	if headerContainsToken(req.Header, "Upgrade", "websocket") && headerContainsToken(req.Header, "Connection", "upgrade") {
+		return ProtocolWebSocket, nil
+	}
+	return ProtocolHTTP, nil
+}
+
+// headerContainsToken 判断逗号分隔的请求头中是否包含指定 token（忽略大小写）
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewHttpCtx 创建HTTP上下文
+func (g *GNetUtil) NewHttpCtx(c gnet.Conn) GnetContext {
+	ctx := &HTTPContext{
+		config: g.config,
+		conn:   c,
+	}
+	ctx.initMeta(c)
+	g.watchIdle(ctx)
+	return ctx
+}
+
+// HTTPContext 普通 HTTP/1.x 连接上下文
+type HTTPContext struct {
+	connMeta
+	conn      gnet.Conn
+	config    *GNetConfig
+	mutex     sync.Mutex
+	continued bool // 已为当前请求回复 100 Continue
+}
+
+func (h *HTTPContext) GetType() string {
+	return "http"
+}
+
+func (h *HTTPContext) Close() error {
+	return h.conn.Close()
+}
+
+func (h *HTTPContext) Conn() gnet.Conn {
+	return h.conn
+}
+
+// Write 写入原始响应数据
+func (h *HTTPContext) Write(data []byte) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, err := h.conn.Write(data); err != nil {
+		return err
+	}
+	h.touchWrite()
+	return nil
+}
+
+// HandleHttpTraffic 处理HTTP流量，支持 keep-alive 与管线化请求，按请求顺序依次响应；
+// 请求不完整时保留在缓冲区等待后续数据。请求头或请求体超出上限时返回 431/413，
+// handler panic 时返回 500，三种情况均会关闭连接。带 Expect: 100-continue 的请求
+// 在请求体未收全时先回复 100 Continue
+func (g *GNetUtil) HandleHttpTraffic(c gnet.Conn, handler http.Handler) error {
+	ctx, ok := c.Context().(*HTTPContext)
+	if !ok {
+		return errors.New("invalid http context")
+	}
+
+	for c.InboundBuffered() > 0 {
+		peek, err := c.Peek(-1)
+		if err != nil {
+			return fmt.Errorf("peek http request failed: %v", err)
+		}
+		// 管线化的后续请求不经过 DetectProtocol，请求头上限在这里检查
+		end := bytes.Index(peek, []byte("\r\n\r\n"))
+		if (end < 0 && len(peek) > g.config.MaxHTTPHeaderSize) || end+4 > g.config.MaxHTTPHeaderSize {
+			ctx.writeStatus(http.StatusRequestHeaderFieldsTooLarge)
+			return errors.New("http header too large")
+		}
+		if end < 0 {
+			return nil
+		}
+
+		reader := bytes.NewReader(peek)
+		br := bufio.NewReaderSize(reader, g.config.ReaderSize)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			ctx.writeStatus(http.StatusBadRequest)
+			return fmt.Errorf("parse http request failed: %v", err)
+		}
+		if req.ContentLength > g.config.MaxHTTPBodySize {
+			ctx.writeStatus(http.StatusRequestEntityTooLarge)
+			return errors.New("http body too large")
+		}
+		// 多读一个字节用于判断分块请求体是否超出上限
+		body, err := io.ReadAll(io.LimitReader(req.Body, g.config.MaxHTTPBodySize+1))
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return ctx.expectContinue(req)
+			}
+			return fmt.Errorf("read http body failed: %v", err)
+		}
+		if int64(len(body)) > g.config.MaxHTTPBodySize {
+			ctx.writeStatus(http.StatusRequestEntityTooLarge)
+			return errors.New("http body too large")
+		}
+		if _, err = c.Discard(len(peek) - reader.Len() - br.Buffered()); err != nil {
+			return fmt.Errorf("discard http request failed: %v", err)
+		}
+		ctx.touchRead()
+		ctx.continued = false
+
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if addr := ctx.RemoteAddr(); addr != nil {
+			req.RemoteAddr = addr.String()
+		}
+		keepAlive := !req.Close
+		rw := &httpResponseWriter{header: make(http.Header)}
+		if err = serveHTTP(handler, rw, req); err != nil {
+			ctx.writeStatus(http.StatusInternalServerError)
+			return err
+		}
+		if err = ctx.Write(rw.bytes(req, keepAlive)); err != nil {
+			return err
+		}
+		if !keepAlive {
+			return ctx.Close()
+		}
+	}
+	return nil
+}
+
+// serveHTTP 调用 handler 并恢复 panic，避免单个请求拖垮事件循环
+func serveHTTP(handler http.Handler, rw http.ResponseWriter, req *http.Request) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("http handler panic", "method", req.Method, "path", req.URL.Path, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("http handler panic: %v", r)
+		}
+	}()
+	handler.ServeHTTP(rw, req)
+	return nil
+}
+
+// expectContinue 请求体未收全且客户端在等待 100 Continue 时回复一次
+func (h *HTTPContext) expectContinue(req *http.Request) error {
+	if h.continued || !strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		return nil
+	}
+	h.continued = true
+	return h.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+}
+
+// writeStatus 写出不带响应体的错误响应，调用方随后关闭连接
+func (h *HTTPContext) writeStatus(status int) {
+	_ = h.Write(statusResponse(status))
+}
+
+// statusResponse 只有状态行的响应，发送后关闭连接
+func statusResponse(status int) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n\r\n", status, http.StatusText(status)))
+}
+
+// httpResponseWriter 缓冲响应内容，处理完成后一次性写出
+type httpResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *httpResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *httpResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *httpResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// bytes 序列化为 HTTP/1.1 响应报文
+func (w *httpResponseWriter) bytes(req *http.Request, keepAlive bool) []byte {
+	w.WriteHeader(http.StatusOK)
+	if w.header.Get("Date") == "" {
+		w.header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if w.header.Get("Content-Type") == "" && w.body.Len() > 0 {
+		w.header.Set("Content-Type", http.DetectContentType(w.body.Bytes()))
+	}
+	resp := &http.Response{
+		StatusCode:    w.status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        w.header,
+		Body:          io.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+		Close:         !keepAlive,
+	}
+	var buf bytes.Buffer
+	_ = resp.Write(&buf)
+	return buf.Bytes()
+}
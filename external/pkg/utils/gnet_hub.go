@@ -0,0 +1,421 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	userTopicPrefix = "user:"
+	roomTopicPrefix = "room:"
+	presenceTopic   = "presence"
+	// DefaultPresenceTTL 节点超过该时间未发送心跳时视为下线，其用户从在线状态中移除
+	DefaultPresenceTTL = 30 * time.Second
+)
+
+// presenceEvent 在线状态变更事件，通过总线广播给所有节点。
+// Heartbeat 为 true 时是节点心跳，Users 为该节点当前全部在线用户
+type presenceEvent struct {
+	UserID    string   `json:"user_id,omitempty"`
+	NodeID    string   `json:"node_id"`
+	Online    bool     `json:"online"`
+	Heartbeat bool     `json:"heartbeat,omitempty"`
+	Users     []string `json:"users,omitempty"`
+}
+
+// Presence 集群在线状态：记录每个用户连接在哪些节点上。
+// 状态由各节点通过总线广播维护；节点心跳携带其全部在线用户，新加入或分区恢复的节点
+// 在一个心跳周期内同步完整状态，崩溃的节点在 ttl 后被移除
+type Presence struct {
+	bus   Bus
+	ttl   time.Duration
+	mutex sync.RWMutex
+	nodes map[string]map[string]struct{} // userID -> nodeIDs
+	seen  map[string]time.Time           // nodeID -> 最近一次收到事件的时间
+	unsub func()
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// NewPresence 基于总线创建在线状态表，节点超过 DefaultPresenceTTL 未发送心跳即被移除
+func NewPresence(bus Bus) (*Presence, error) {
+	return newPresence(bus, DefaultPresenceTTL)
+}
+
+func newPresence(bus Bus, ttl time.Duration) (*Presence, error) {
+	p := &Presence{
+		bus:   bus,
+		ttl:   ttl,
+		nodes: make(map[string]map[string]struct{}),
+		seen:  make(map[string]time.Time),
+		stop:  make(chan struct{}),
+	}
+	unsub, err := bus.Subscribe(presenceTopic, p.apply)
+	if err != nil {
+		return nil, err
+	}
+	p.unsub = unsub
+	go p.sweep()
+	return p, nil
+}
+
+// Locate 查询用户所在的节点
+func (p *Presence) Locate(userID string) []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	nodes := make([]string, 0, len(p.nodes[userID]))
+	for node := range p.nodes[userID] {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Close 停止接收在线状态变更
+func (p *Presence) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+		p.unsub()
+	})
+}
+
+// sweep 定期移除心跳超时的节点
+func (p *Presence) sweep() {
+	ticker := time.NewTicker(p.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.expire(now)
+		}
+	}
+}
+
+func (p *Presence) expire(now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for node, seen := range p.seen {
+		if now.Sub(seen) <= p.ttl {
+			continue
+		}
+		slog.Warn("presence node expired", "nodeID", node, "lastSeen", seen)
+		delete(p.seen, node)
+		for userID := range p.nodes {
+			p.remove(userID, node)
+		}
+	}
+}
+
+// heartbeat 广播本节点心跳与全部在线用户
+func (p *Presence) heartbeat(nodeID string, users []string) error {
+	data, err := json.Marshal(presenceEvent{NodeID: nodeID, Online: true, Heartbeat: true, Users: users})
+	if err != nil {
+		return err
+	}
+	return p.bus.Publish(presenceTopic, data)
+}
+
+func (p *Presence) publish(userID, nodeID string, online bool) error {
+	data, err := json.Marshal(presenceEvent{UserID: userID, NodeID: nodeID, Online: online})
+	if err != nil {
+		return err
+	}
+	return p.bus.Publish(presenceTopic, data)
+}
+
+func (p *Presence) apply(_ string, message []byte) {
+	var event presenceEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		slog.Error("invalid presence event", "error", err)
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.seen[event.NodeID] = time.Now()
+	if event.Heartbeat {
+		// 以心跳中的用户列表为准，替换该节点的全部在线状态
+		users := make(map[string]struct{}, len(event.Users))
+		for _, userID := range event.Users {
+			users[userID] = struct{}{}
+			p.add(userID, event.NodeID)
+		}
+		for userID := range p.nodes {
+			if _, ok := users[userID]; !ok {
+				p.remove(userID, event.NodeID)
+			}
+		}
+		return
+	}
+	if event.Online {
+		p.add(event.UserID, event.NodeID)
+		return
+	}
+	p.remove(event.UserID, event.NodeID)
+}
+
+// add 与 remove 需持有锁
+func (p *Presence) add(userID, nodeID string) {
+	if p.nodes[userID] == nil {
+		p.nodes[userID] = make(map[string]struct{})
+	}
+	p.nodes[userID][nodeID] = struct{}{}
+}
+
+func (p *Presence) remove(userID, nodeID string) {
+	delete(p.nodes[userID], nodeID)
+	if len(p.nodes[userID]) == 0 {
+		delete(p.nodes, userID)
+	}
+}
+
+// hubMember 单个连接在 Hub 中的绑定关系
+type hubMember struct {
+	userID string
+	rooms  map[string]struct{}
+}
+
+// Hub 将总线消息投递到本节点的连接：按用户或房间订阅主题，
+// 本节点第一个连接加入时订阅，最后一个连接离开时取消订阅
+type Hub struct {
+	nodeID      string
+	bus         Bus
+	presence    *Presence
+	presenceTTL time.Duration
+	mutex       sync.Mutex
+	topics      map[string]map[uint64]GnetContext
+	unsubs      map[string]func()
+	members     map[uint64]*hubMember
+	stop        chan struct{}
+	once        sync.Once
+}
+
+// HubOption Hub 配置选项
+type HubOption func(*Hub)
+
+// WithPresenceTTL 设置节点心跳超时，节点每 ttl/3 广播一次心跳，默认 DefaultPresenceTTL
+func WithPresenceTTL(ttl time.Duration) HubOption {
+	return func(h *Hub) {
+		h.presenceTTL = ttl
+	}
+}
+
+// NewHub 创建连接中心，nodeID 在集群内唯一
+func NewHub(nodeID string, bus Bus, opts ...HubOption) (*Hub, error) {
+	h := &Hub{
+		nodeID:      nodeID,
+		bus:         bus,
+		presenceTTL: DefaultPresenceTTL,
+		topics:      make(map[string]map[uint64]GnetContext),
+		unsubs:      make(map[string]func()),
+		members:     make(map[uint64]*hubMember),
+		stop:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	presence, err := newPresence(bus, h.presenceTTL)
+	if err != nil {
+		return nil, err
+	}
+	h.presence = presence
+	go h.heartbeat()
+	return h, nil
+}
+
+// Close 停止心跳与在线状态同步，不广播下线；其它节点在心跳超时后移除本节点的用户
+func (h *Hub) Close() {
+	h.once.Do(func() {
+		close(h.stop)
+		h.presence.Close()
+	})
+}
+
+// heartbeat 定期广播本节点的全部在线用户。持锁发布，保证心跳不会晚于
+// 之后的上下线事件到达，旧快照不会覆盖新状态
+func (h *Hub) heartbeat() {
+	ticker := time.NewTicker(h.presenceTTL / 3)
+	defer ticker.Stop()
+	for {
+		h.mutex.Lock()
+		users := make([]string, 0, len(h.topics))
+		for topic := range h.topics {
+			if userID, ok := strings.CutPrefix(topic, userTopicPrefix); ok {
+				users = append(users, userID)
+			}
+		}
+		err := h.presence.heartbeat(h.nodeID, users)
+		h.mutex.Unlock()
+		if err != nil {
+			slog.Debug("publish presence heartbeat failed", "nodeID", h.nodeID, "error", err)
+		}
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Bind 将连接绑定到用户，一个连接只能绑定一个用户
+func (h *Hub) Bind(userID string, ctx GnetContext) error {
+	id, ok := connID(ctx)
+	if !ok {
+		return errNoConnAttrs
+	}
+	h.mutex.Lock()
+	member := h.member(id)
+	if member.userID != "" {
+		h.mutex.Unlock()
+		return errors.New("connection already bound to a user")
+	}
+	member.userID = userID
+	first, err := h.join(userTopicPrefix+userID, id, ctx)
+	h.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if first {
+		return h.presence.publish(userID, h.nodeID, true)
+	}
+	return nil
+}
+
+// Join 加入房间
+func (h *Hub) Join(room string, ctx GnetContext) error {
+	id, ok := connID(ctx)
+	if !ok {
+		return errNoConnAttrs
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.member(id).rooms[room] = struct{}{}
+	_, err := h.join(roomTopicPrefix+room, id, ctx)
+	return err
+}
+
+// Leave 离开房间
+func (h *Hub) Leave(room string, ctx GnetContext) {
+	id, ok := connID(ctx)
+	if !ok {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if member, ok := h.members[id]; ok {
+		delete(member.rooms, room)
+	}
+	h.leave(roomTopicPrefix+room, id)
+}
+
+// Unbind 连接断开时调用，解除用户绑定并离开所有房间
+func (h *Hub) Unbind(ctx GnetContext) {
+	id, ok := connID(ctx)
+	if !ok {
+		return
+	}
+	h.mutex.Lock()
+	member, ok := h.members[id]
+	if !ok {
+		h.mutex.Unlock()
+		return
+	}
+	delete(h.members, id)
+	for room := range member.rooms {
+		h.leave(roomTopicPrefix+room, id)
+	}
+	last := false
+	if member.userID != "" {
+		last = h.leave(userTopicPrefix+member.userID, id)
+	}
+	h.mutex.Unlock()
+
+	if last {
+		if err := h.presence.publish(member.userID, h.nodeID, false); err != nil {
+			slog.Error("publish presence failed", "userID", member.userID, "error", err)
+		}
+	}
+}
+
+// SendToUser 向用户的所有连接发送消息，用户可以连接在任意节点
+func (h *Hub) SendToUser(userID string, message []byte) error {
+	return h.bus.Publish(userTopicPrefix+userID, message)
+}
+
+// SendToRoom 向房间内的所有连接发送消息
+func (h *Hub) SendToRoom(room string, message []byte) error {
+	return h.bus.Publish(roomTopicPrefix+room, message)
+}
+
+// Locate 查询用户所在的节点
+func (h *Hub) Locate(userID string) []string {
+	return h.presence.Locate(userID)
+}
+
+func (h *Hub) member(id uint64) *hubMember {
+	member, ok := h.members[id]
+	if !ok {
+		member = &hubMember{rooms: make(map[string]struct{})}
+		h.members[id] = member
+	}
+	return member
+}
+
+// join 将连接加入主题，返回是否为本节点该主题的第一个连接；需持有锁
+func (h *Hub) join(topic string, id uint64, ctx GnetContext) (bool, error) {
+	conns, ok := h.topics[topic]
+	if !ok {
+		unsub, err := h.bus.Subscribe(topic, h.deliver)
+		if err != nil {
+			return false, err
+		}
+		conns = make(map[uint64]GnetContext)
+		h.topics[topic] = conns
+		h.unsubs[topic] = unsub
+	}
+	conns[id] = ctx
+	return !ok, nil
+}
+
+// leave 将连接移出主题，返回是否为本节点该主题的最后一个连接；需持有锁
+func (h *Hub) leave(topic string, id uint64) bool {
+	conns, ok := h.topics[topic]
+	if !ok {
+		return false
+	}
+	delete(conns, id)
+	if len(conns) > 0 {
+		return false
+	}
+	delete(h.topics, topic)
+	h.unsubs[topic]()
+	delete(h.unsubs, topic)
+	return true
+}
+
+// deliver 将总线消息写给本节点订阅该主题的连接。回调运行在总线协程中，
+// 内置连接通过 AsyncWrite 交给事件循环发送，其它实现退回 Write
+func (h *Hub) deliver(topic string, message []byte) {
+	h.mutex.Lock()
+	conns := make(map[uint64]GnetContext, len(h.topics[topic]))
+	for id, ctx := range h.topics[topic] {
+		conns[id] = ctx
+	}
+	h.mutex.Unlock()
+
+	for id, ctx := range conns {
+		write := ctx.Write
+		if w, ok := ctx.(asyncWriter); ok {
+			write = w.asyncWrite
+		}
+		if err := write(message); err != nil {
+			slog.Debug("deliver bus message failed", "topic", topic, "id", id, "error", err)
+		}
+	}
+}
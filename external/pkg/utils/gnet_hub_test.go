@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/panjf2000/gnet/v2"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// hubTestCtx 记录写入消息的上下文
+type hubTestCtx struct {
+	connMeta
+	messages chan string
+}
+
+func newHubTestCtx() *hubTestCtx {
+	ctx := &hubTestCtx{messages: make(chan string, 8)}
+	ctx.initMeta(nil)
+	return ctx
+}
+
+func (h *hubTestCtx) GetType() string      { return "test" }
+func (h *hubTestCtx) Close() error         { return nil }
+func (h *hubTestCtx) Conn() gnet.Conn      { return nil }
+func (h *hubTestCtx) Write(b []byte) error { h.messages <- string(b); return nil }
+
+func expectMessage(t *testing.T, ctx *hubTestCtx, want string) {
+	t.Helper()
+	select {
+	case got := <-ctx.messages:
+		if got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout waiting for %q", want)
+	}
+}
+
+func TestHubTCPBus(t *testing.T) {
+	bus1, err := NewTCPBus("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus1.Close()
+	bus2, err := NewTCPBus("127.0.0.1:0", bus1.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus2.Close()
+	bus1.AddPeer(bus2.Addr())
+
+	hub1, err := NewHub("node1", bus1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub1.Close()
+	hub2, err := NewHub("node2", bus2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub2.Close()
+
+	alice, bob := newHubTestCtx(), newHubTestCtx()
+	if err = hub1.Bind("alice", alice); err != nil {
+		t.Fatal(err)
+	}
+	if err = hub2.Bind("bob", bob); err != nil {
+		t.Fatal(err)
+	}
+	_ = hub1.Join("lobby", alice)
+	_ = hub2.Join("lobby", bob)
+
+	// 跨节点投递
+	if err = hub1.SendToUser("bob", []byte("hi bob")); err != nil {
+		t.Fatal(err)
+	}
+	expectMessage(t, bob, "hi bob")
+
+	if err = hub2.SendToRoom("lobby", []byte("hello all")); err != nil {
+		t.Fatal(err)
+	}
+	expectMessage(t, alice, "hello all")
+	expectMessage(t, bob, "hello all")
+
+	// 在线状态在两个节点上一致
+	deadline := time.Now().Add(3 * time.Second)
+	for !reflect.DeepEqual(hub1.Locate("bob"), []string{"node2"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("presence not propagated: %v", hub1.Locate("bob"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hub2.Unbind(bob)
+	for len(hub1.Locate("bob")) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("offline not propagated: %v", hub1.Locate("bob"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = hub1.SendToRoom("lobby", []byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	expectMessage(t, alice, "bye")
+	select {
+	case msg := <-bob.messages:
+		t.Fatalf("unbound connection received %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// hubAsyncCtx 只允许通过 asyncWrite 投递的上下文
+type hubAsyncCtx struct {
+	*hubTestCtx
+	t *testing.T
+}
+
+func (h hubAsyncCtx) Write([]byte) error {
+	h.t.Error("deliver called Write off the event loop")
+	return nil
+}
+
+func (h hubAsyncCtx) asyncWrite(b []byte) error { return h.hubTestCtx.Write(b) }
+
+func TestHubAsyncDeliver(t *testing.T) {
+	hub, err := NewHub("node1", NewMemoryBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+	ctx := hubAsyncCtx{newHubTestCtx(), t}
+	if err = hub.Bind("alice", ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = hub.SendToUser("alice", []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	expectMessage(t, ctx.hubTestCtx, "hi")
+}
+
+func TestTCPBusUnreachablePeer(t *testing.T) {
+	// 192.0.2.0/24 为文档保留地址，连接不会成功
+	bus, err := NewTCPBus("127.0.0.1:0", "192.0.2.1:9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if err = bus.Publish("topic", []byte("message")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("publish blocked for %v", elapsed)
+	}
+	start = time.Now()
+	_ = bus.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("close blocked for %v", elapsed)
+	}
+}
+
+func TestTCPBusOversizedFrame(t *testing.T) {
+	bus, err := NewTCPBus("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bus.Close()
+	bus.SetMaxFrameSize(64)
+	received := make(chan string, 1)
+	if _, err = bus.Subscribe("topic", func(topic string, message []byte) {
+		received <- string(message)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = bus.Publish("topic", make([]byte, 64)); !errors.Is(err, errBusFrameTooLarge) {
+		t.Fatalf("publish oversized = %v", err)
+	}
+
+	// 对端声明 4GB 的消息长度，监听方不分配缓冲区，直接断开
+	conn, err := net.Dial("tcp", bus.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte{0, 5, 't', 'o', 'p', 'i', 'c', 0xff, 0xff, 0xff, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read = %v, want EOF", err)
+	}
+
+	frame, err := encodeBusFrame("topic", []byte("ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err = net.Dial("tcp", bus.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "ok" {
+			t.Fatalf("got %q", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for frame within the limit")
+	}
+}
+
+// waitLocate 等待用户所在节点变为 want
+func waitLocate(t *testing.T, locate func(string) []string, userID string, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for got := locate(userID); !reflect.DeepEqual(got, want); got = locate(userID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("locate %s = %v, want %v", userID, got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPresenceNodeCrash(t *testing.T) {
+	bus := NewMemoryBus()
+	hub, err := NewHub("node1", bus, WithPresenceTTL(300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+	alice := newHubTestCtx()
+	if err = hub.Bind("alice", alice); err != nil {
+		t.Fatal(err)
+	}
+
+	// node2 上线一个用户后崩溃，不再发送心跳也没有下线事件
+	data, _ := json.Marshal(presenceEvent{UserID: "ghost", NodeID: "node2", Online: true})
+	if err = bus.Publish(presenceTopic, data); err != nil {
+		t.Fatal(err)
+	}
+	if got := hub.Locate("ghost"); !reflect.DeepEqual(got, []string{"node2"}) {
+		t.Fatalf("locate ghost = %v", got)
+	}
+	waitLocate(t, hub.Locate, "ghost", []string{})
+	// 本节点持续心跳，用户不会过期
+	waitLocate(t, hub.Locate, "alice", []string{"node1"})
+
+	// 之后加入的节点通过心跳同步已有的在线用户
+	late, err := newPresence(bus, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	waitLocate(t, late.Locate, "alice", []string{"node1"})
+}
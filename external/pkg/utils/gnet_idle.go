@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"github.com/gobwas/ws"
+	"github.com/panjf2000/gnet/v2"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// idleWheelScale 空闲检测时间轮槽位数，1 秒一格，一圈一小时
+const idleWheelScale = 3600
+
+// IdleKind 空闲类型
+type IdleKind int8
+
+const (
+	// IdleRead 超过 ReadIdleTimeout 未收到数据
+	IdleRead IdleKind = iota
+	// IdleWrite 超过 WriteIdleTimeout 未发送数据
+	IdleWrite
+	// IdleAll 超过 AllIdleTimeout 既未收到也未发送数据
+	IdleAll
+)
+
+func (k IdleKind) String() string {
+	switch k {
+	case IdleRead:
+		return "read"
+	case IdleWrite:
+		return "write"
+	case IdleAll:
+		return "all"
+	default:
+		return "unknown"
+	}
+}
+
+// IdleHandler 空闲回调，可以发送 ping 或关闭连接；回调在连接所在的事件循环中执行，
+// 可以直接调用 Write 和 Close。连接保持空闲时每经过一个超时周期会再次触发
+type IdleHandler func(ctx GnetContext, kind IdleKind)
+
+func (c *GNetConfig) idleEnabled() bool {
+	return c.ReadIdleTimeout > 0 || c.WriteIdleTimeout > 0 || c.AllIdleTimeout > 0
+}
+
+// watchIdle 将连接加入时间轮，所有连接共用同一个时间轮，不为单个连接创建协程
+// 仅配置心跳时时间轮同样存在，此时不加入，避免每个连接留下一个永不到期的任务
+func (g *GNetUtil) watchIdle(ctx GnetContext) {
+	if g.wheel == nil || !g.config.idleEnabled() {
+		return
+	}
+	delay := min(g.idleTimeout(g.config.ReadIdleTimeout),
+		g.idleTimeout(g.config.WriteIdleTimeout),
+		g.idleTimeout(g.config.AllIdleTimeout))
+	if err := g.wheel.AddTask(ctx, g.checkIdle, delay); err != nil {
+		slog.Error("add idle check task failed", "error", err)
+	}
+}
+
+// idleTimeout 未启用的超时视为无穷大，便于取最小值
+func (g *GNetUtil) idleTimeout(d time.Duration) time.Duration {
+	if d <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return d
+}
+
+// checkIdle 时间轮任务：检查空闲状态并按最近的截止时间重新调度
+func (g *GNetUtil) checkIdle(data any, tc TaskContext) {
+	ctx, ok := data.(GnetContext)
+	if !ok {
+		return
+	}
+	h, ok := ctx.(metaHolder)
+	if !ok {
+		return
+	}
+	m := h.meta()
+
+	now := time.Now()
+	next := time.Duration(math.MaxInt64)
+	check := func(kind IdleKind, timeout time.Duration, last time.Time) {
+		if timeout <= 0 || m.released.Load() {
+			return
+		}
+		remain := timeout - now.Sub(last)
+		if remain <= 0 {
+			g.fireIdle(ctx, m, kind)
+			remain = timeout
+		}
+		next = min(next, remain)
+	}
+	check(IdleRead, g.config.ReadIdleTimeout, m.LastRead())
+	check(IdleWrite, g.config.WriteIdleTimeout, m.LastWrite())
+	check(IdleAll, g.config.AllIdleTimeout, m.LastActive())
+
+	if m.released.Load() {
+		return
+	}
+	if err := tc.AddTask(ctx, g.checkIdle, max(next, time.Second)); err != nil {
+		slog.Error("reschedule idle check task failed", "error", err)
+	}
+}
+
+// startHeartbeat WebSocket 升级成功后开始心跳
+func (g *GNetUtil) startHeartbeat(ctx *WSContext) {
+	if g.wheel == nil || g.config.Heartbeat <= 0 {
+		return
+	}
+	ctx.pongState.Store(true)
+	if err := g.wheel.AddTask(ctx, g.heartbeat, g.config.Heartbeat); err != nil {
+		slog.Error("add heartbeat task failed", "error", err)
+	}
+}
+
+// heartbeat 时间轮任务：上一次 ping 未收到 pong 则关闭连接，否则发送下一次 ping
+func (g *GNetUtil) heartbeat(data any, tc TaskContext) {
+	ctx, ok := data.(*WSContext)
+	if !ok || ctx.released.Load() {
+		return
+	}
+	if !ctx.pongState.Load() {
+		slog.Debug("heartbeat timeout", "id", ctx.ID())
+		onLoop(ctx, func() { _ = ctx.Close() })
+		return
+	}
+	ctx.pongState.Store(false)
+	// 时间轮协程不在事件循环内，ping 交给事件循环发送
+	if err := ctx.asyncWriteFrame(ws.NewPingFrame(nil)); err != nil {
+		slog.Debug("send ping failed", "id", ctx.ID(), "error", err)
+		onLoop(ctx, func() { _ = ctx.Close() })
+		return
+	}
+	if err := tc.AddTask(ctx, g.heartbeat, g.config.Heartbeat); err != nil {
+		slog.Error("reschedule heartbeat task failed", "error", err)
+	}
+}
+
+func (g *GNetUtil) fireIdle(ctx GnetContext, m *connMeta, kind IdleKind) {
+	if handler := g.config.IdleHandler; handler != nil {
+		onLoop(ctx, func() {
+			if !m.released.Load() {
+				handler(ctx, kind)
+			}
+		})
+		return
+	}
+	slog.Debug("close idle connection", "id", m.ID(), "kind", kind.String())
+	m.released.Store(true)
+	onLoop(ctx, func() {
+		if err := ctx.Close(); err != nil {
+			slog.Debug("close idle connection failed", "error", err)
+		}
+	})
+}
+
+// onLoop 经 Wake 将时间轮中的操作交给连接所在的事件循环执行，时间轮协程自身不读写连接；
+// 连接尚未绑定时不执行
+func onLoop(ctx GnetContext, f func()) {
+	c := ctx.Conn()
+	if c == nil {
+		return
+	}
+	if err := c.Wake(func(gnet.Conn, error) error {
+		f()
+		return nil
+	}); err != nil {
+		slog.Debug("wake connection failed", "error", err)
+	}
+}
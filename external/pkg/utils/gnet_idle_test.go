@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"context"
+	"github.com/gobwas/ws"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// dialTestWs 连接测试服务并完成握手，返回原始连接以便直接读取控制帧
+func dialTestWs(t *testing.T, addr string) net.Conn {
+	conn, _, _, err := ws.Dial(context.Background(), "ws://"+addr+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestIdleClose(t *testing.T) {
+	_, addr := startTestServer(t, WithGNetUtil(NewGNetUtil(WithIdleTimeout(time.Second, 0, 0))))
+	conn := dialTestWs(t, addr)
+
+	// 不发送任何数据，读空闲超时后服务端关闭连接
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read = %v, want EOF", err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("closed after %v, before the idle timeout", elapsed)
+	}
+}
+
+func TestHeartbeatPing(t *testing.T) {
+	_, addr := startTestServer(t, WithGNetUtil(NewGNetUtil(WithHeartbeat(time.Second))))
+	conn := dialTestWs(t, addr)
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame, err := ws.ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Header.OpCode != ws.OpPing {
+		t.Fatalf("got opcode %v, want ping", frame.Header.OpCode)
+	}
+
+	// 未回复 pong，下一次心跳时连接被关闭
+	if _, err = ws.ReadFrame(conn); err != io.EOF && err != io.ErrUnexpectedEOF {
+		t.Fatalf("read = %v, want EOF", err)
+	}
+}
+
+func TestIdleHandlerWrite(t *testing.T) {
+	handler := func(ctx GnetContext, kind IdleKind) {
+		// 回调在事件循环中执行，可以直接写入
+		_ = ctx.Write([]byte("idle:" + kind.String()))
+	}
+	_, addr := startTestServer(t, WithGNetUtil(NewGNetUtil(WithIdleTimeout(time.Second, 0, 0), WithIdleHandler(handler))))
+	conn := dialTestWs(t, addr)
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame, err := ws.ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(frame.Payload); got != "idle:read" {
+		t.Fatalf("got %q, want idle:read", got)
+	}
+}
+
+// wheelTasks 统计时间轮中携带 data 的任务数
+func wheelTasks(tw *TimingWheel, data any) int {
+	count := 0
+	for _, n := range tw.nodes {
+		n.lock.Lock()
+		for _, t := range n.tasks {
+			if t.data == data {
+				count++
+			}
+		}
+		n.lock.Unlock()
+	}
+	return count
+}
+
+func TestIdleReleased(t *testing.T) {
+	// 仅配置心跳时不为连接加入空闲检测任务
+	heartbeat := NewGNetUtil(WithHeartbeat(time.Second))
+	t.Cleanup(heartbeat.Stop)
+	ctx := heartbeat.NewWsCtx()
+	heartbeat.Release(ctx)
+	if n := wheelTasks(heartbeat.wheel, ctx); n != 0 {
+		t.Fatalf("heartbeat only: %d pending idle tasks", n)
+	}
+
+	// 释放后的连接在下一次检查时移出时间轮，不再重新调度
+	idle := NewGNetUtil(WithIdleTimeout(time.Second, 0, 0))
+	t.Cleanup(idle.Stop)
+	ctx = idle.NewWsCtx()
+	if n := wheelTasks(idle.wheel, ctx); n != 1 {
+		t.Fatalf("watched: %d pending idle tasks", n)
+	}
+	idle.Release(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for wheelTasks(idle.wheel, ctx) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("released context still in the wheel")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"errors"
+	"github.com/gobwas/ws"
+	"github.com/panjf2000/gnet/v2"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRateLimited 入站流量超限且策略为 LimitClose 时由 HandleWsTraffic 返回
+var ErrRateLimited = errors.New("inbound rate limit exceeded")
+
+// errMessageTooLarge 消息超过 MaxMessageSize，HandleWsTraffic 会先发送 1009 关闭帧
+var errMessageTooLarge = errors.New("message too large")
+
+// LimitAction 入站流量超限后的处理方式
+type LimitAction int8
+
+const (
+	// LimitDrop 丢弃超限的消息
+	LimitDrop LimitAction = iota
+	// LimitDelay 暂停解析后续数据，令牌恢复后继续投递
+	LimitDelay
+	// LimitClose 发送 1008 Policy Violation 关闭帧并断开连接
+	LimitClose
+)
+
+func (a LimitAction) String() string {
+	switch a {
+	case LimitDrop:
+		return "drop"
+	case LimitDelay:
+		return "delay"
+	case LimitClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// defaultMaxDelayedBytes 延迟模式下默认的积压字节上限
+const defaultMaxDelayedBytes = 4 * 1024 * 1024
+
+// RateLimit 单连接入站限流配置，速率为 0 表示不限制该维度；
+// 突发容量为 0 时取一秒的速率
+type RateLimit struct {
+	MessagesPerSecond float64
+	MessageBurst      float64
+	BytesPerSecond    float64
+	ByteBurst         float64
+	Action            LimitAction
+	// MaxDelayedBytes LimitDelay 模式下积压消息与缓冲区中未解析数据的字节上限，0 时为 4MB
+	MaxDelayedBytes int
+	// DelayOverflow 超出 MaxDelayedBytes 后的处理方式：LimitDrop 解析并丢弃新到达的消息，
+	// LimitClose（或 LimitDelay）发送 1008 关闭帧并断开连接
+	DelayOverflow LimitAction
+}
+
+// WithRateLimit 设置 WebSocket 单连接入站限流（令牌桶），控制帧不计入限流，
+// 设置 FragmentHandler 的流式读取模式下不生效
+func WithRateLimit(limit RateLimit) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.RateLimit = &limit
+	}
+}
+
+// InboundStats 连接入站流量统计，用于排查异常客户端
+type InboundStats struct {
+	Messages uint64 // 收到的数据消息数
+	Bytes    uint64 // 收到的数据消息字节数
+	Dropped  uint64 // 因限流丢弃的消息数
+	Delayed  uint64 // 因限流延迟投递的消息数
+	Limited  uint64 // 触发限流的次数
+}
+
+// inboundCounter 入站流量计数，并发安全
+type inboundCounter struct {
+	messages atomic.Uint64
+	bytes    atomic.Uint64
+	dropped  atomic.Uint64
+	delayed  atomic.Uint64
+	limited  atomic.Uint64
+}
+
+func (c *inboundCounter) snapshot() InboundStats {
+	return InboundStats{
+		Messages: c.messages.Load(),
+		Bytes:    c.bytes.Load(),
+		Dropped:  c.dropped.Load(),
+		Delayed:  c.delayed.Load(),
+		Limited:  c.limited.Load(),
+	}
+}
+
+// tokenBucket 令牌桶，rate 为每秒补充的令牌数
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait 返回获得 n 个令牌还需等待的时间，0 表示当前可用；超过突发容量的请求按装满计算
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	n = math.Min(n, b.burst)
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= math.Min(n, b.burst)
+	}
+}
+
+// connLimiter 单连接限流状态，pending 为延迟模式下等待投递的消息
+type connLimiter struct {
+	mutex      sync.Mutex
+	action     LimitAction
+	messages   *tokenBucket
+	bytes      *tokenBucket
+	pending    [][]byte
+	waking     bool
+	maxDelayed int
+	overflow   LimitAction
+}
+
+func newConnLimiter(limit *RateLimit) *connLimiter {
+	if limit == nil || (limit.MessagesPerSecond <= 0 && limit.BytesPerSecond <= 0) {
+		return nil
+	}
+	maxDelayed := limit.MaxDelayedBytes
+	if maxDelayed <= 0 {
+		maxDelayed = defaultMaxDelayedBytes
+	}
+	return &connLimiter{
+		action:     limit.Action,
+		messages:   newTokenBucket(limit.MessagesPerSecond, limit.MessageBurst),
+		bytes:      newTokenBucket(limit.BytesPerSecond, limit.ByteBurst),
+		maxDelayed: maxDelayed,
+		overflow:   limit.DelayOverflow,
+	}
+}
+
+// allow 判断消息是否可以立即投递，可以时扣除令牌，否则返回需等待的时间
+func (l *connLimiter) allow(size int) time.Duration {
+	now := time.Now()
+	for _, b := range []*tokenBucket{l.messages, l.bytes} {
+		if b != nil {
+			b.refill(now)
+		}
+	}
+	wait := max(l.messages.wait(1), l.bytes.wait(float64(size)))
+	if wait == 0 {
+		l.messages.take(1)
+		l.bytes.take(float64(size))
+	}
+	return wait
+}
+
+// admit 按限流策略筛选本批次消息，返回可以立即投递的消息
+func (w *WSContext) admit(c gnet.Conn, messages [][]byte) ([][]byte, error) {
+	l := w.limiter
+	if l == nil {
+		return messages, nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// 延迟模式下先投递积压的消息，保持消息顺序
+	backlog := len(l.pending)
+	queue := append(l.pending, messages...)
+	l.pending = nil
+	var admitted [][]byte
+	for i, message := range queue {
+		wait := l.allow(len(message))
+		if wait == 0 {
+			admitted = append(admitted, message)
+			continue
+		}
+
+		w.stats.limited.Add(1)
+		switch l.action {
+		case LimitClose:
+			slog.Warn("websocket rate limit exceeded, closing", "id", w.ID(), "remote", w.RemoteAddr())
+			_ = w.writeFrame(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusPolicyViolation, "rate limit exceeded")))
+			return admitted, ErrRateLimited
+		case LimitDelay:
+			l.pending = queue[i:]
+			w.stats.delayed.Add(uint64(len(queue) - max(i, backlog)))
+			if !l.waking {
+				l.waking = true
+				time.AfterFunc(wait, func() {
+					l.mutex.Lock()
+					l.waking = false
+					l.mutex.Unlock()
+					_ = c.Wake(nil)
+				})
+			}
+			return admitted, nil
+		default:
+			w.stats.dropped.Add(1)
+			slog.Debug("websocket message dropped by rate limit", "id", w.ID(), "remote", w.RemoteAddr(), "size", len(message))
+		}
+	}
+	return admitted, nil
+}
+
+// delaying 延迟模式下是否有积压消息，有积压时不再解析新的数据帧
+func (w *WSContext) delaying() bool {
+	if w.limiter == nil {
+		return false
+	}
+	w.limiter.mutex.Lock()
+	defer w.limiter.mutex.Unlock()
+	return len(w.limiter.pending) > 0
+}
+
+// delayOverflow 延迟期间积压消息与 buffered 字节的未解析数据超过上限时返回 true；
+// 溢出策略为关闭时发送 1008 关闭帧并返回 ErrRateLimited
+func (w *WSContext) delayOverflow(buffered int) (bool, error) {
+	l := w.limiter
+	l.mutex.Lock()
+	size := buffered
+	for _, message := range l.pending {
+		size += len(message)
+	}
+	overflow := l.overflow
+	l.mutex.Unlock()
+	if size <= l.maxDelayed {
+		return false, nil
+	}
+
+	w.stats.limited.Add(1)
+	if overflow == LimitDrop {
+		slog.Debug("websocket delay buffer full, dropping new messages", "id", w.ID(), "remote", w.RemoteAddr(), "size", size)
+		return true, nil
+	}
+	slog.Warn("websocket delay buffer full, closing", "id", w.ID(), "remote", w.RemoteAddr(), "size", size)
+	_ = w.writeFrame(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusPolicyViolation, "rate limit exceeded")))
+	return true, ErrRateLimited
+}
+
+// InboundStats 入站流量统计
+func (w *WSContext) InboundStats() InboundStats {
+	return w.stats.snapshot()
+}
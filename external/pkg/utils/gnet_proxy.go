@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/panjf2000/gnet/v2"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	proxyV1Prefix    = "PROXY "
+	proxyV1MaxLength = 107
+	proxyV2HeaderLen = 16
+)
+
+// proxyV2Signature PROXY protocol v2 固定签名
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// WithProxyProtocol 开启 HAProxy PROXY protocol v1/v2 解析，required 为 true 时拒绝不带头部的连接。
+// 配置了 WithTrustedProxies 时，仅接受来自可信代理的头部
+func WithProxyProtocol(required bool) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.ProxyProtocol = true
+		c.ProxyProtocolRequired = required
+	}
+}
+
+// WithTrustedProxies 设置可信代理（CIDR 或单个 IP），WebSocket 握手时仅信任来自这些地址的
+// X-Forwarded-For / X-Real-IP
+func WithTrustedProxies(proxies ...string) GNetUtilOption {
+	return func(c *GNetConfig) {
+		for _, proxy := range proxies {
+			if !strings.Contains(proxy, "/") {
+				if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+					proxy += "/32"
+				} else {
+					proxy += "/128"
+				}
+			}
+			_, ipNet, err := net.ParseCIDR(proxy)
+			if err != nil {
+				slog.Error("invalid trusted proxy", "proxy", proxy, "error", err)
+				continue
+			}
+			c.TrustedProxies = append(c.TrustedProxies, ipNet)
+		}
+	}
+}
+
+// isTrustedProxy 判断地址是否属于可信代理
+func (c *GNetConfig) isTrustedProxy(ip net.IP) bool {
+	for _, ipNet := range c.TrustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadProxyHeader 在协议识别前解析并消费 PROXY 头部。
+// complete 为 false 表示数据不足需等待；addr 为空表示没有头部或为 LOCAL/UNKNOWN 连接
+func (g *GNetUtil) ReadProxyHeader(c gnet.Conn) (addr net.Addr, complete bool, err error) {
+	buffered := c.InboundBuffered()
+	if buffered <= 0 {
+		return nil, false, nil
+	}
+	data, err := c.Peek(buffered)
+	if err != nil {
+		return nil, false, fmt.Errorf("peek proxy header failed: %v", err)
+	}
+
+	addr, n, err := parseProxyHeader(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if n < 0 {
+		return nil, false, nil
+	}
+	if n == 0 {
+		if g.config.ProxyProtocolRequired {
+			return nil, false, errors.New("proxy protocol header required")
+		}
+		return nil, true, nil
+	}
+
+	// 只接受可信代理发来的头部，防止客户端伪造地址
+	if len(g.config.TrustedProxies) > 0 {
+		if peer, ok := c.RemoteAddr().(*net.TCPAddr); !ok || !g.config.isTrustedProxy(peer.IP) {
+			return nil, false, fmt.Errorf("proxy header from untrusted peer %v", c.RemoteAddr())
+		}
+	}
+	if _, err = c.Discard(n); err != nil {
+		return nil, false, fmt.Errorf("discard proxy header failed: %v", err)
+	}
+	return addr, true, nil
+}
+
+// SetRealAddr 用 PROXY 头部中的客户端地址覆盖上下文的 RemoteAddr，PeerAddr 保持为代理地址
+func (g *GNetUtil) SetRealAddr(ctx GnetContext, addr net.Addr) {
+	if h, ok := ctx.(metaHolder); ok && addr != nil {
+		h.meta().remoteAddr.Store(addr)
+	}
+}
+
+// parseProxyHeader 解析 PROXY 头部，n 为头部长度，-1 表示数据不足，0 表示不是 PROXY 头部
+func parseProxyHeader(data []byte) (addr net.Addr, n int, err error) {
+	switch {
+	case len(data) < len(proxyV2Signature) && bytes.HasPrefix(proxyV2Signature, data):
+		return nil, -1, nil
+	case bytes.HasPrefix(data, proxyV2Signature):
+		return parseProxyV2(data)
+	case len(data) < len(proxyV1Prefix) && bytes.HasPrefix([]byte(proxyV1Prefix), data):
+		return nil, -1, nil
+	case bytes.HasPrefix(data, []byte(proxyV1Prefix)):
+		return parseProxyV1(data)
+	default:
+		return nil, 0, nil
+	}
+}
+
+// parseProxyV1 文本格式：PROXY TCP4 src dst sport dport\r\n
+func parseProxyV1(data []byte) (net.Addr, int, error) {
+	end := bytes.Index(data, []byte("\r\n"))
+	if end < 0 {
+		if len(data) >= proxyV1MaxLength {
+			return nil, 0, errors.New("proxy v1 header too long")
+		}
+		return nil, -1, nil
+	}
+	if end+2 > proxyV1MaxLength {
+		return nil, 0, errors.New("proxy v1 header too long")
+	}
+
+	fields := strings.Fields(string(data[:end]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, end + 2, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, 0, fmt.Errorf("invalid proxy v1 header: %q", data[:end])
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, 0, fmt.Errorf("invalid proxy v1 address: %q", data[:end])
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, end + 2, nil
+}
+
+// parseProxyV2 二进制格式，只解析 TCP over IPv4/IPv6 的源地址，忽略 TLV 扩展
+func parseProxyV2(data []byte) (net.Addr, int, error) {
+	if len(data) < proxyV2HeaderLen {
+		return nil, -1, nil
+	}
+	verCmd, family := data[12], data[13]
+	if verCmd>>4 != 2 {
+		return nil, 0, fmt.Errorf("unsupported proxy protocol version: %d", verCmd>>4)
+	}
+	total := proxyV2HeaderLen + int(binary.BigEndian.Uint16(data[14:16]))
+	if len(data) < total {
+		return nil, -1, nil
+	}
+
+	// LOCAL 命令为代理自身的健康检查，使用真实连接地址
+	if verCmd&0x0F == 0x00 {
+		return nil, total, nil
+	}
+	if verCmd&0x0F != 0x01 {
+		return nil, 0, fmt.Errorf("unsupported proxy v2 command: %d", verCmd&0x0F)
+	}
+
+	payload := data[proxyV2HeaderLen:total]
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, 0, errors.New("invalid proxy v2 ipv4 address")
+		}
+		ip := net.IP(bytes.Clone(payload[0:4]))
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[8:10]))}, total, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, 0, errors.New("invalid proxy v2 ipv6 address")
+		}
+		ip := net.IP(bytes.Clone(payload[0:16]))
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[32:34]))}, total, nil
+	default:
+		return nil, total, nil
+	}
+}
+
+// applyForwarded 握手请求来自可信代理时，用 X-Forwarded-For / X-Real-IP 中的客户端地址覆盖 RemoteAddr
+func (w *WSContext) applyForwarded(header http.Header) {
+	if len(w.config.TrustedProxies) == 0 {
+		return
+	}
+	peer, ok := w.RemoteAddr().(*net.TCPAddr)
+	if !ok || !w.config.isTrustedProxy(peer.IP) {
+		return
+	}
+	if ip := forwardedClientIP(header, w.config); ip != nil {
+		w.remoteAddr.Store(&net.TCPAddr{IP: ip})
+	}
+}
+
+// forwardedClientIP 从右向左跳过可信代理，第一个非可信地址即客户端地址
+func forwardedClientIP(header http.Header, config *GNetConfig) net.IP {
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	var client net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !config.isTrustedProxy(ip) {
+			return ip
+		}
+	}
+	if client != nil {
+		return client
+	}
+	return net.ParseIP(strings.TrimSpace(header.Get("X-Real-IP")))
+}
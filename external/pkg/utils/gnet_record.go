@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gobwas/ws"
+	"github.com/panjf2000/gnet/v2"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// RecordIn 客户端发来的消息
+	RecordIn = "in"
+	// RecordOut 服务端发出的消息
+	RecordOut = "out"
+)
+
+// Record 一条录制的 WebSocket 消息
+type Record struct {
+	ConnID    uint64    `json:"conn_id"`
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	OpCode    ws.OpCode `json:"op_code"`
+	Payload   []byte    `json:"payload"`
+	// Truncated 流式读取的消息超过 MaxMessageSize，Payload 只包含前 MaxMessageSize 字节
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Recorder 消息录制接口，实现需并发安全
+type Recorder interface {
+	Record(record Record)
+}
+
+// RecordFilter 判断连接的消息是否需要录制，每条消息都会调用，可依据握手后设置的连接属性
+type RecordFilter func(ctx GnetContext) bool
+
+// WithRecorder 开启 WebSocket 消息录制，filter 为空时录制所有连接
+func WithRecorder(recorder Recorder, filter ...RecordFilter) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.Recorder = recorder
+		if len(filter) > 0 {
+			c.RecordFilter = filter[0]
+		}
+	}
+}
+
+// RecordAttr 只录制属性 key 等于 value 的连接；按 reflect.DeepEqual 比较，
+// 切片、map 等不可比较的属性值不会在事件循环中引发 panic
+func RecordAttr(key string, value any) RecordFilter {
+	return func(ctx GnetContext) bool {
+		v, ok := GetAs[any](ctx, key)
+		return ok && reflect.DeepEqual(v, value)
+	}
+}
+
+// recording 按配置判断当前连接是否需要录制
+func (w *WSContext) recording() bool {
+	if w.config.Recorder == nil {
+		return false
+	}
+	filter := w.config.RecordFilter
+	return filter == nil || filter(w)
+}
+
+// record 按配置录制一条消息，payload 会被复制
+func (w *WSContext) record(direction string, op ws.OpCode, payload []byte) {
+	if w.recording() {
+		w.emitRecord(direction, op, payload, false)
+	}
+}
+
+func (w *WSContext) emitRecord(direction string, op ws.OpCode, payload []byte, truncated bool) {
+	w.config.Recorder.Record(Record{
+		ConnID:    w.ID(),
+		Time:      time.Now(),
+		Direction: direction,
+		OpCode:    op,
+		Payload:   bytes.Clone(payload),
+		Truncated: truncated,
+	})
+}
+
+// streamRecord 流式读取时拼接分片，消息结束时作为一条完整消息录制，
+// 超过 MaxMessageSize 的部分不保留
+type streamRecord struct {
+	op        ws.OpCode
+	buf       bytes.Buffer
+	truncated bool
+}
+
+// recordFragment 录制流式读取的分片，在事件循环中调用
+func (w *WSContext) recordFragment(op ws.OpCode, fragment []byte, fin bool) {
+	if w.stream == nil {
+		if !w.recording() {
+			return
+		}
+		w.stream = &streamRecord{op: op}
+	}
+	s := w.stream
+	if remain := w.config.MaxMessageSize - int64(s.buf.Len()); int64(len(fragment)) > remain {
+		fragment = fragment[:max(remain, 0)]
+		s.truncated = true
+	}
+	s.buf.Write(fragment)
+	if fin {
+		w.emitRecord(RecordIn, s.op, s.buf.Bytes(), s.truncated)
+		w.stream = nil
+	}
+}
+
+// RingRecorder 环形缓冲录制器，只保留最近 size 条消息
+type RingRecorder struct {
+	mutex   sync.Mutex
+	records []Record
+	next    int
+	full    bool
+}
+
+// NewRingRecorder 创建环形缓冲录制器
+func NewRingRecorder(size int) *RingRecorder {
+	return &RingRecorder{records: make([]Record, max(size, 1))}
+}
+
+func (r *RingRecorder) Record(record Record) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.records[r.next] = record
+	r.next = (r.next + 1) % len(r.records)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Records 按时间顺序返回缓冲区中的消息，connID 非空时只返回这些连接的消息
+func (r *RingRecorder) Records(connID ...uint64) []Record {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	ordered := r.records[:r.next]
+	if r.full {
+		ordered = append(append([]Record{}, r.records[r.next:]...), r.records[:r.next]...)
+	}
+	records := make([]Record, 0, len(ordered))
+	for _, record := range ordered {
+		if len(connID) == 0 || containsID(connID, record.ConnID) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// FileRecorder 以 JSON Lines 格式追加写入文件的录制器
+type FileRecorder struct {
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// NewFileRecorder 打开或创建录制文件
+func NewFileRecorder(path string) (*FileRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open record file failed: %v", err)
+	}
+	return &FileRecorder{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (f *FileRecorder) Record(record Record) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, _ = f.writer.Write(append(data, '\n'))
+}
+
+// Flush 将缓冲的记录写入文件
+func (f *FileRecorder) Flush() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.writer.Flush()
+}
+
+// Close 写入剩余记录并关闭文件
+func (f *FileRecorder) Close() error {
+	if err := f.Flush(); err != nil {
+		return err
+	}
+	return f.file.Close()
+}
+
+// ReadRecords 读取 FileRecorder 写入的录制文件
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	decoder := json.NewDecoder(r)
+	for {
+		var record Record
+		if err := decoder.Decode(&record); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return nil, fmt.Errorf("decode record failed: %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+// ReplayContext 回放使用的连接上下文，记录处理过程中写出的消息
+type ReplayContext struct {
+	connMeta
+	mutex   sync.Mutex
+	written [][]byte
+}
+
+func (r *ReplayContext) GetType() string {
+	return "replay"
+}
+
+func (r *ReplayContext) Close() error {
+	return nil
+}
+
+func (r *ReplayContext) Conn() gnet.Conn {
+	return nil
+}
+
+func (r *ReplayContext) Write(data []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.written = append(r.written, bytes.Clone(data))
+	r.touchWrite()
+	return nil
+}
+
+// Written 回放过程中 handler 写出的消息
+func (r *ReplayContext) Written() [][]byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.written
+}
+
+// Replay 将录制的入站数据消息按顺序交给 handler，用于在测试中复现问题；
+// 录制中包含多个连接时需先按 ConnID 过滤
+func Replay(records []Record, handler func(ctx GnetContext, message []byte)) *ReplayContext {
+	ctx := &ReplayContext{}
+	ctx.initMeta(nil)
+	for _, record := range records {
+		if record.Direction != RecordIn || record.OpCode.IsControl() {
+			continue
+		}
+		ctx.touchRead()
+		handler(ctx, record.Payload)
+	}
+	return ctx
+}
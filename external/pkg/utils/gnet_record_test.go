@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"context"
+	"github.com/gobwas/ws"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// teeRecorder 同时写入多个录制器
+type teeRecorder []Recorder
+
+func (t teeRecorder) Record(record Record) {
+	for _, r := range t {
+		r.Record(record)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ring := NewRingRecorder(16)
+	path := filepath.Join(t.TempDir(), "ws.jsonl")
+	file, err := NewFileRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	util := NewGNetUtil(WithRecorder(teeRecorder{ring, file}, RecordAttr("debug", true)))
+	_, addr := startTestServer(t, WithGNetUtil(util),
+		WithOnConnect(func(ctx GnetContext) {
+			if ws := ctx.(*WSContext); ws.GetQuery().Get("debug") == "1" {
+				ws.Set("debug", true)
+			}
+		}),
+		WithOnMessage(func(ctx GnetContext, message []byte) {
+			_ = ctx.Write([]byte(strings.ToUpper(string(message))))
+		}))
+
+	for _, url := range []string{"ws://" + addr + "/?debug=1", "ws://" + addr + "/"} {
+		received := make(chan string, 2)
+		client, err := DialWs(context.Background(), url, nil, WithClientHandler(func(ctx GnetContext, message []byte) {
+			received <- string(message)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range []string{"a", "b"} {
+			_ = client.Write([]byte(msg))
+			select {
+			case <-received:
+			case <-time.After(3 * time.Second):
+				t.Fatal("timeout waiting for echo")
+			}
+		}
+		_ = client.Close()
+	}
+
+	// 只录制了带 debug 属性的连接，关闭帧等控制帧同样会被录制
+	var got []string
+	for _, record := range ring.Records() {
+		if !record.OpCode.IsControl() {
+			got = append(got, record.Direction+":"+string(record.Payload))
+		}
+	}
+	if strings.Join(got, ",") != "in:a,out:A,in:b,out:B" {
+		t.Fatalf("got %v", got)
+	}
+
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := ReadRecords(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) < 4 || records[0].ConnID != ring.Records()[0].ConnID {
+		t.Fatalf("records %+v", records)
+	}
+
+	ctx := Replay(records, func(ctx GnetContext, message []byte) {
+		_ = ctx.Write(append(message, '!'))
+	})
+	if written := ctx.Written(); len(written) != 2 || string(written[0]) != "a!" || string(written[1]) != "b!" {
+		t.Fatalf("replayed %q", written)
+	}
+}
+
+func TestRecordFragmented(t *testing.T) {
+	ring := NewRingRecorder(16)
+	streamed := make(chan string, 1)
+	var buf strings.Builder
+	util := NewGNetUtil(WithRecorder(ring), WithFragmentSize(4),
+		WithFragmentHandler(func(ctx GnetContext, op ws.OpCode, fragment []byte, fin bool) {
+			buf.Write(fragment)
+			if !fin {
+				return
+			}
+			// 以分片形式回写收到的完整消息
+			w, err := ctx.(*WSContext).NextWriter(op)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = w.Write([]byte(strings.ToUpper(buf.String())))
+			_ = w.Close()
+			streamed <- buf.String()
+			buf.Reset()
+		}))
+	_, addr := startTestServer(t, WithGNetUtil(util))
+
+	client, err := DialWs(context.Background(), "ws://"+addr+"/", nil, WithClientFragmentSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	w, err := client.NextWriter(ws.OpText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("hello fragments"))
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-streamed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for fragmented message")
+	}
+
+	var got []string
+	for _, record := range ring.Records() {
+		if !record.OpCode.IsControl() {
+			got = append(got, record.Direction+":"+string(record.Payload))
+		}
+	}
+	if strings.Join(got, ",") != "in:hello fragments,out:HELLO FRAGMENTS" {
+		t.Fatalf("got %v", got)
+	}
+
+	ctx := Replay(ring.Records(), func(ctx GnetContext, message []byte) {
+		_ = ctx.Write(message)
+	})
+	if written := ctx.Written(); len(written) != 1 || string(written[0]) != "hello fragments" {
+		t.Fatalf("replayed %q", written)
+	}
+}
+
+func TestRecordAttr(t *testing.T) {
+	ctx := NewGNetUtil().NewWsCtx()
+	ctx.(ConnAttrs).Set("tags", []string{"debug"})
+	ctx.(ConnAttrs).Set("user", "u1")
+
+	// 不可比较的属性值按内容比较，不会 panic
+	if !RecordAttr("tags", []string{"debug"})(ctx) {
+		t.Fatal("equal slice not matched")
+	}
+	if RecordAttr("tags", "debug")(ctx) || RecordAttr("user", map[string]int{"u1": 1})(ctx) {
+		t.Fatal("different value matched")
+	}
+	if !RecordAttr("user", "u1")(ctx) || RecordAttr("missing", nil)(ctx) {
+		t.Fatal("comparable value")
+	}
+}
@@ -0,0 +1,352 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"github.com/gobwas/ws"
+	"github.com/panjf2000/gnet/v2"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GNetServer 通用 gnet 服务端，按首包自动区分 WebSocket、HTTP 与 TCP 连接
+type GNetServer struct {
+	gnet.BuiltinEventEngine
+	engine       gnet.Engine
+	booted       atomic.Bool
+	addr         string
+	options      gnet.Options
+	gNetUtil     *GNetUtil
+	wsHandlers   []func(ctx *WSContext) error
+	httpHandler  http.Handler
+	onConnect    func(ctx GnetContext)
+	onMessage    func(ctx GnetContext, message []byte)
+	onDisconnect func(ctx GnetContext, err error)
+	conns        sync.Map // ID -> GnetContext
+	connected    atomic.Int64
+}
+
+// GNetServerOption 服务端配置选项
+type GNetServerOption func(*GNetServer)
+
+// WithGNetUtil 设置协议处理工具，默认使用 NewGNetUtil()
+func WithGNetUtil(g *GNetUtil) GNetServerOption {
+	return func(s *GNetServer) {
+		s.gNetUtil = g
+	}
+}
+
+// WithGNetOptions 设置 gnet 引擎参数
+func WithGNetOptions(options gnet.Options) GNetServerOption {
+	return func(s *GNetServer) {
+		s.options = options
+	}
+}
+
+// WithWsUpgradeHandlers 设置 WebSocket 握手阶段的业务处理，如鉴权
+func WithWsUpgradeHandlers(handlers ...func(ctx *WSContext) error) GNetServerOption {
+	return func(s *GNetServer) {
+		s.wsHandlers = handlers
+	}
+}
+
+// WithHttpHandler 设置普通 HTTP 请求的处理器，如 /healthz、/metrics，未设置时返回 404
+func WithHttpHandler(handler http.Handler) GNetServerOption {
+	return func(s *GNetServer) {
+		s.httpHandler = handler
+	}
+}
+
+// WithOnConnect 连接就绪回调，WebSocket 在握手成功后触发，HTTP 连接不触发
+func WithOnConnect(f func(ctx GnetContext)) GNetServerOption {
+	return func(s *GNetServer) {
+		s.onConnect = f
+	}
+}
+
+// WithOnMessage 消息回调，在事件循环中执行，耗时业务需自行投递到协程池
+func WithOnMessage(f func(ctx GnetContext, message []byte)) GNetServerOption {
+	return func(s *GNetServer) {
+		s.onMessage = f
+	}
+}
+
+// WithOnDisconnect 连接断开回调，仅对已识别协议的 WebSocket 与 TCP 连接触发
+func WithOnDisconnect(f func(ctx GnetContext, err error)) GNetServerOption {
+	return func(s *GNetServer) {
+		s.onDisconnect = f
+	}
+}
+
+// NewGNetServer 创建服务端，addr 形如 tcp://:8080
+func NewGNetServer(addr string, opts ...GNetServerOption) *GNetServer {
+	s := &GNetServer{
+		addr:    addr,
+		options: gnet.Options{Multicore: true, ReusePort: true},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.gNetUtil == nil {
+		s.gNetUtil = NewGNetUtil()
+	}
+	if s.httpHandler == nil {
+		s.httpHandler = http.NotFoundHandler()
+	}
+	return s
+}
+
+// Start 启动服务端，阻塞直到引擎停止
+func (s *GNetServer) Start() error {
+	return gnet.Run(s, s.addr, gnet.WithOptions(s.options))
+}
+
+// Shutdown 向所有 WebSocket 连接发送关闭帧后停止引擎
+func (s *GNetServer) Shutdown(ctx context.Context) error {
+	if !s.booted.Load() {
+		return errors.New("server not started")
+	}
+	closeFrame, err := ws.CompileFrame(ws.NewCloseFrame(ws.NewCloseFrameBody(ws.StatusGoingAway, "server shutdown")))
+	if err != nil {
+		return err
+	}
+
+	// 关闭帧在事件循环中异步写出，等待写完或 ctx 超时后再停止引擎
+	var wg sync.WaitGroup
+	s.Range(func(c GnetContext) bool {
+		wsCtx, ok := c.(*WSContext)
+		if !ok {
+			return true
+		}
+		wsCtx.mutex.Lock()
+		upgraded := wsCtx.upgraded
+		wsCtx.mutex.Unlock()
+		conn := wsCtx.Conn()
+		if !upgraded {
+			return true
+		}
+		wg.Add(1)
+		if err := conn.AsyncWrite(closeFrame, func(gnet.Conn, error) error {
+			wg.Done()
+			return nil
+		}); err != nil {
+			wg.Done()
+			slog.Debug("send close frame failed", "id", wsCtx.ID(), "error", err)
+		}
+		return true
+	})
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	defer s.gNetUtil.Stop()
+	return s.engine.Stop(ctx)
+}
+
+// Count 当前连接数
+func (s *GNetServer) Count() int64 {
+	return s.connected.Load()
+}
+
+// Range 遍历已识别协议的连接，f 返回 false 时停止
+func (s *GNetServer) Range(f func(ctx GnetContext) bool) {
+	s.conns.Range(func(_, value any) bool {
+		return f(value.(GnetContext))
+	})
+}
+
+func (s *GNetServer) OnBoot(engine gnet.Engine) gnet.Action {
+	s.engine = engine
+	s.booted.Store(true)
+	return gnet.None
+}
+
+// OnOpen 为连接建立识别前的状态并开始握手计时，超过 HandshakeTimeout 仍未识别出协议的连接被关闭，
+// 不发送数据或只发送部分 PROXY、TLS、HTTP 头部的客户端不会一直占用连接
+func (s *GNetServer) OnOpen(c gnet.Conn) ([]byte, gnet.Action) {
+	s.connected.Add(1)
+	pending := &pendingConn{proxyDone: !s.gNetUtil.config.ProxyProtocol}
+	if timeout := s.gNetUtil.config.HandshakeTimeout; timeout > 0 {
+		remote := c.RemoteAddr()
+		pending.timer = time.AfterFunc(timeout, func() {
+			slog.Debug("handshake timeout", "remote", remote)
+			_ = c.Close()
+		})
+	}
+	c.SetContext(pending)
+	return nil, gnet.None
+}
+
+func (s *GNetServer) OnClose(c gnet.Conn, err error) gnet.Action {
+	s.connected.Add(-1)
+	ctx, ok := c.Context().(GnetContext)
+	if !ok {
+		if pending, ok := c.Context().(*pendingConn); ok {
+			pending.stop()
+		}
+		return gnet.None
+	}
+	id, _ := connID(ctx)
+	if err != nil && !errors.Is(err, io.EOF) {
+		slog.Debug("connection error", "id", id, "error", err)
+	}
+	s.conns.Delete(id)
+	s.gNetUtil.Release(ctx)
+	if _, isHttp := ctx.(*HTTPContext); !isHttp && s.onDisconnect != nil {
+		s.onDisconnect(ctx, err)
+	}
+	return gnet.None
+}
+
+func (s *GNetServer) OnTraffic(c gnet.Conn) gnet.Action {
+	ctx, ok := c.Context().(GnetContext)
+	if !ok {
+		var err error
+		if ctx, err = s.accept(c); err != nil {
+			slog.Error("detect protocol failed", "error", err)
+			return gnet.Close
+		}
+		if ctx == nil {
+			return gnet.None
+		}
+	}
+
+	id, _ := connID(ctx)
+
+	// TLS 连接先解密，后续处理均基于明文连接
+	conn := ctx.Conn()
+	if t, ok := conn.(*TLSConn); ok {
+		if err := t.Feed(); err != nil {
+			slog.Debug("tls feed failed", "id", id, "error", err)
+			return gnet.Close
+		}
+	}
+
+	var err error
+	switch t := ctx.(type) {
+	case *WSContext:
+		err = s.handleWs(conn, t)
+	case *HTTPContext:
+		err = s.gNetUtil.HandleHttpTraffic(conn, s.httpHandler)
+	case *TCPContext:
+		err = s.gNetUtil.HandleTcpTraffic(conn, func(message []byte) {
+			s.dispatch(t, message)
+		})
+	}
+	if err != nil {
+		slog.Error("handle traffic failed", "id", id, "error", err)
+		return gnet.Close
+	}
+	return gnet.None
+}
+
+// pendingConn 协议识别完成前的连接状态
+type pendingConn struct {
+	proxyDone bool
+	realAddr  net.Addr
+	tls       *TLSConn
+	timer     *time.Timer // 握手计时，识别出协议后停止
+}
+
+// stop 连接在识别出协议前关闭时停止计时并释放 TLS 层
+func (p *pendingConn) stop() {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if p.tls != nil {
+		p.tls.shutdown()
+	}
+}
+
+// accept 解析 PROXY 头部后根据首包识别协议并创建上下文，数据不足以判断时返回 nil
+func (s *GNetServer) accept(c gnet.Conn) (GnetContext, error) {
+	pending, ok := c.Context().(*pendingConn)
+	if !ok {
+		pending = &pendingConn{proxyDone: !s.gNetUtil.config.ProxyProtocol}
+		c.SetContext(pending)
+	}
+	if !pending.proxyDone {
+		addr, complete, err := s.gNetUtil.ReadProxyHeader(c)
+		if err != nil || !complete {
+			return nil, err
+		}
+		pending.proxyDone = true
+		pending.realAddr = addr
+	}
+
+	conn := c
+	if s.gNetUtil.config.TLSConfig != nil {
+		if pending.tls == nil {
+			t, err := s.gNetUtil.NewTLSConn(c)
+			if err != nil {
+				return nil, err
+			}
+			pending.tls = t
+		}
+		if err := pending.tls.Feed(); err != nil {
+			return nil, err
+		}
+		conn = pending.tls
+	}
+
+	protocol, err := s.gNetUtil.DetectProtocol(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx GnetContext
+	switch protocol {
+	case ProtocolWebSocket:
+		ctx = s.gNetUtil.NewWsCtxWithConn(conn)
+	case ProtocolHTTP:
+		ctx = s.gNetUtil.NewHttpCtx(conn)
+	case ProtocolTCP:
+		ctx = s.gNetUtil.NewTcpCtx(conn)
+	default:
+		return nil, nil
+	}
+	if pending.timer != nil {
+		pending.timer.Stop()
+	}
+	s.gNetUtil.SetRealAddr(ctx, pending.realAddr)
+	c.SetContext(ctx)
+	id, _ := connID(ctx)
+	s.conns.Store(id, ctx)
+	if protocol == ProtocolTCP && s.onConnect != nil {
+		s.onConnect(ctx)
+	}
+	return ctx, nil
+}
+
+// handleWs 握手完成后先触发 OnConnect，再投递同一批次中的消息
+func (s *GNetServer) handleWs(c gnet.Conn, ctx *WSContext) error {
+	upgraded := ctx.upgraded
+	var messages [][]byte
+	err := s.gNetUtil.HandleWsTraffic(c, func(message []byte) {
+		messages = append(messages, message)
+	}, s.wsHandlers...)
+	if !upgraded && ctx.upgraded && s.onConnect != nil {
+		s.onConnect(ctx)
+	}
+	for _, message := range messages {
+		s.dispatch(ctx, message)
+	}
+	return err
+}
+
+func (s *GNetServer) dispatch(ctx GnetContext, message []byte) {
+	if s.onMessage != nil {
+		s.onMessage(ctx, message)
+	}
+}
@@ -0,0 +1,684 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/panjf2000/gnet/v2"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func startTestServer(t *testing.T, opts ...GNetServerOption) (*GNetServer, string) {
+	addr := freeAddr(t)
+	server := NewGNetServer(fmt.Sprintf("tcp://%s", addr), opts...)
+	go func() {
+		if err := server.Start(); err != nil {
+			t.Error(err)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			_ = conn.Close()
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	})
+	return server, addr
+}
+
+func TestGNetServer(t *testing.T) {
+	connected := make(chan GnetContext, 2)
+	server, addr := startTestServer(t,
+		WithGNetUtil(NewGNetUtil(WithCodec(LengthFieldCodec{MaxFrameLength: 1024}))),
+		WithOnConnect(func(ctx GnetContext) {
+			ctx.(ConnAttrs).Set("user", "u1")
+			connected <- ctx
+		}),
+		WithOnMessage(func(ctx GnetContext, message []byte) {
+			user, _ := GetAs[string](ctx, "user")
+			_ = ctx.Write([]byte(user + ":" + string(message)))
+		}),
+	)
+
+	wsConn, _, _, err := ws.Dial(context.Background(), "ws://"+addr+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wsConn.Close()
+	ctx := <-connected
+	attrs := ctx.(ConnAttrs)
+	if ctx.GetType() != "ws" || attrs.RemoteAddr() == nil || attrs.ID() == 0 {
+		t.Fatalf("unexpected context: %s %v %d", ctx.GetType(), attrs.RemoteAddr(), attrs.ID())
+	}
+	for _, msg := range []string{"a", "b"} {
+		if err = wsutil.WriteClientText(wsConn, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"u1:a", "u1:b"} {
+		data, err := wsutil.ReadServerText(wsConn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("got %q, want %q", data, want)
+		}
+	}
+
+	tcpConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpConn.Close()
+	frame := make([]byte, 4+5)
+	binary.BigEndian.PutUint32(frame, 5)
+	copy(frame[4:], "hello")
+	if _, err = tcpConn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+	if ctx = <-connected; ctx.GetType() != "tcp" {
+		t.Fatalf("unexpected type %s", ctx.GetType())
+	}
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(tcpConn, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err = io.ReadFull(tcpConn, body); err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "u1:hello" {
+		t.Fatalf("got %q", body)
+	}
+	if server.Count() != 2 {
+		t.Fatalf("count = %d", server.Count())
+	}
+}
+
+func TestGNetServerHTTP(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	_, addr := startTestServer(t, WithHttpHandler(mux))
+
+	resp, err := http.Get("http://" + addr + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("got %d %q", resp.StatusCode, body)
+	}
+
+	// 管线化：两个请求一次发出，按顺序得到两个响应
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: a\r\n\r\nPOST /missing HTTP/1.1\r\nHost: a\r\nContent-Length: 2\r\n\r\nhi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		resp, err = http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != want {
+			t.Fatalf("got %d, want %d", resp.StatusCode, want)
+		}
+	}
+}
+
+func TestGNetServerHTTPLimits(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	_, addr := startTestServer(t, WithHttpHandler(mux),
+		WithGNetUtil(NewGNetUtil(WithHttpLimits(256, 16))))
+
+	// send 发出原始请求，依次读取响应状态码
+	send := func(t *testing.T, raw string, want ...int) {
+		t.Helper()
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err = conn.Write([]byte(raw)); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		br := bufio.NewReader(conn)
+		for _, status := range want {
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			if resp.StatusCode != status {
+				t.Fatalf("got %d, want %d", resp.StatusCode, status)
+			}
+		}
+	}
+
+	t.Run("body", func(t *testing.T) {
+		send(t, "POST /echo HTTP/1.1\r\nHost: a\r\nContent-Length: 17\r\n\r\n", http.StatusRequestEntityTooLarge)
+		chunked := "POST /echo HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n11\r\n" + strings.Repeat("a", 17) + "\r\n0\r\n\r\n"
+		send(t, chunked, http.StatusRequestEntityTooLarge)
+	})
+	t.Run("first header", func(t *testing.T) {
+		// 首个请求的请求头在协议识别阶段超限，同样回复 431
+		send(t, "GET /echo HTTP/1.1\r\nHost: a\r\nX-Large: "+strings.Repeat("a", 256), http.StatusRequestHeaderFieldsTooLarge)
+	})
+	t.Run("pipelined header", func(t *testing.T) {
+		large := "GET /echo HTTP/1.1\r\nHost: a\r\nX-Large: " + strings.Repeat("a", 256) + "\r\n\r\n"
+		send(t, "GET /echo HTTP/1.1\r\nHost: a\r\n\r\n"+large, http.StatusOK, http.StatusRequestHeaderFieldsTooLarge)
+	})
+	t.Run("panic", func(t *testing.T) {
+		send(t, "GET /panic HTTP/1.1\r\nHost: a\r\n\r\n", http.StatusInternalServerError)
+		send(t, "GET /echo HTTP/1.1\r\nHost: a\r\n\r\n", http.StatusOK)
+	})
+	t.Run("expect continue", func(t *testing.T) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("POST /echo HTTP/1.1\r\nHost: a\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\n"))
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		br := bufio.NewReader(conn)
+		line, err := br.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, "HTTP/1.1 100") {
+			t.Fatalf("got %q, %v", line, err)
+		}
+		_, _ = br.ReadString('\n')
+		_, _ = conn.Write([]byte("hi"))
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "hi" {
+			t.Fatalf("got %d %q", resp.StatusCode, body)
+		}
+	})
+}
+
+// peekConn 只支持读取缓冲区的连接，用于协议识别
+type peekConn struct {
+	gnet.Conn
+	data []byte
+}
+
+func (c *peekConn) InboundBuffered() int { return len(c.data) }
+
+func (c *peekConn) Peek(n int) ([]byte, error) {
+	if n < 0 || n > len(c.data) {
+		n = len(c.data)
+	}
+	return c.data[:n], nil
+}
+
+func TestIsWsConn(t *testing.T) {
+	util := NewGNetUtil()
+	upgrade := "GET / HTTP/1.1\r\nHost: a\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+	// 握手请求分段到达时等待后续数据，而不是判定为非 WebSocket
+	for _, data := range []string{"GE", upgrade[:20], upgrade[:len(upgrade)-2]} {
+		if ok, err := util.IsWsConn(&peekConn{data: []byte(data)}); ok || !errors.Is(err, ErrNeedMore) {
+			t.Fatalf("%q: %v, %v", data, ok, err)
+		}
+	}
+	if ok, err := util.IsWsConn(&peekConn{data: []byte(upgrade)}); !ok || err != nil {
+		t.Fatalf("upgrade: %v, %v", ok, err)
+	}
+	if ok, err := util.IsWsConn(&peekConn{data: []byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n")}); ok || err != nil {
+		t.Fatalf("http: %v, %v", ok, err)
+	}
+}
+
+func TestGNetServerProxyProtocol(t *testing.T) {
+	_, addr := startTestServer(t,
+		WithGNetUtil(NewGNetUtil(WithProxyProtocol(true), WithTrustedProxies("127.0.0.1"))),
+		WithHttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.RemoteAddr))
+		})),
+	)
+
+	v2 := append([]byte(nil), proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0x00, 0x0C, 198, 51, 100, 9, 10, 0, 0, 1, 0x1F, 0x90, 0x00, 0x50)
+	cases := map[string][]byte{
+		"203.0.113.7:5555":  []byte("PROXY TCP4 203.0.113.7 10.0.0.1 5555 80\r\n"),
+		"198.51.100.9:8080": v2,
+	}
+	for want, header := range cases {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.Write(append(header, "GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = conn.Close()
+		if string(body) != want {
+			t.Fatalf("got %q, want %q", body, want)
+		}
+	}
+}
+
+func TestGNetServerHandshakeTimeout(t *testing.T) {
+	server, addr := startTestServer(t,
+		WithGNetUtil(NewGNetUtil(WithHandshakeTimeout(300*time.Millisecond), WithProxyProtocol(false))),
+		WithHttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		})),
+	)
+
+	// 不发送数据或只发送部分请求头的连接在超时后被关闭
+	for name, data := range map[string]string{
+		"silent":         "",
+		"partial header": "GET / HTTP/1.1\r\nHost: a\r\n",
+		"partial proxy":  "PROXY TCP4 203.0.113.7",
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("%s: read = %v, want EOF", name, err)
+		}
+		_ = conn.Close()
+	}
+
+	// 已识别协议的连接不受握手超时限制
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		if _, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("request #%d: %v", i, err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		time.Sleep(500 * time.Millisecond)
+	}
+	if n := server.Count(); n != 1 {
+		t.Fatalf("count = %d, want 1", n)
+	}
+}
+
+func writeTestCert(t *testing.T, dnsName string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return
+}
+
+func TestGNetServerTLS(t *testing.T) {
+	store := NewCertStore()
+	certFile, keyFile := writeTestCert(t, "*.example.com")
+	if err := store.Add("*.example.com", certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	_, addr := startTestServer(t,
+		WithGNetUtil(NewGNetUtil(WithTLSConfig(store.TLSConfig("http/1.1")))),
+		WithHttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("secure"))
+		})),
+	)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: "api.example.com", InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}},
+	}}
+	defer client.CloseIdleConnections()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "secure" {
+			t.Fatalf("got %q", body)
+		}
+		if resp.TLS.NegotiatedProtocol != "http/1.1" || resp.TLS.PeerCertificates[0].Subject.CommonName != "*.example.com" {
+			t.Fatalf("unexpected tls state: %q %q", resp.TLS.NegotiatedProtocol, resp.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	}
+}
+
+// tlsRawConn 模拟 gnet 连接：对端写入的密文追加到读缓冲区，AsyncWrite 按序写回对端，
+// loop 串行化 Feed 与明文读取，代替事件循环
+type tlsRawConn struct {
+	gnet.Conn
+	peer    net.Conn
+	mutex   sync.Mutex
+	inbound []byte
+	out     chan []byte
+	loop    sync.Mutex
+	tls     *TLSConn
+	feedErr chan error
+}
+
+func (c *tlsRawConn) InboundBuffered() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.inbound)
+}
+
+func (c *tlsRawConn) Next(n int) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if n < 0 || n > len(c.inbound) {
+		n = len(c.inbound)
+	}
+	buf := c.inbound[:n:n]
+	c.inbound = c.inbound[n:]
+	return buf, nil
+}
+
+func (c *tlsRawConn) AsyncWrite(buf []byte, _ gnet.AsyncCallback) error {
+	c.out <- buf
+	return nil
+}
+
+func (c *tlsRawConn) Wake(gnet.AsyncCallback) error {
+	go c.feed()
+	return nil
+}
+
+func (c *tlsRawConn) Close() error                 { return nil }
+func (c *tlsRawConn) LocalAddr() net.Addr          { return c.peer.LocalAddr() }
+func (c *tlsRawConn) RemoteAddr() net.Addr         { return c.peer.RemoteAddr() }
+func (c *tlsRawConn) SetContext(any)               {}
+func (c *tlsRawConn) Context() any                 { return nil }
+func (c *tlsRawConn) SetReadBuffer(int) error      { return nil }
+func (c *tlsRawConn) OutboundBuffered() int        { return 0 }
+func (c *tlsRawConn) Flush() error                 { return nil }
+func (c *tlsRawConn) Discard(int) (int, error)     { return 0, nil }
+func (c *tlsRawConn) Peek(int) ([]byte, error)     { return nil, nil }
+func (c *tlsRawConn) Writev([][]byte) (int, error) { return 0, nil }
+
+func (c *tlsRawConn) feed() {
+	c.loop.Lock()
+	defer c.loop.Unlock()
+	if err := c.tls.Feed(); err != nil {
+		select {
+		case c.feedErr <- err:
+		default:
+		}
+	}
+}
+
+func TestTLSConnBackpressure(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, "api.example.com")
+	store := NewCertStore()
+	if err := store.Add("", certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	util := NewGNetUtil(WithTLSConfig(store.TLSConfig()), WithMaxMessageSize(1024), WithHttpLimits(1024, 1024))
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	raw := &tlsRawConn{peer: server, out: make(chan []byte, 64), feedErr: make(chan error, 1)}
+	go func() {
+		for buf := range raw.out {
+			if _, err := server.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+	conn, err := util.NewTLSConn(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw.tls = conn
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			raw.mutex.Lock()
+			raw.inbound = append(raw.inbound, buf[:n]...)
+			raw.mutex.Unlock()
+			raw.feed()
+		}
+	}()
+
+	tc := tls.Client(client, &tls.Config{ServerName: "api.example.com", InsecureSkipVerify: true})
+	if err = tc.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	// 明文无人读取，对端持续发送时解密暂停，积压超过上限后 Feed 报错
+	limit := conn.limit
+	payload := make([]byte, 4*limit)
+	go func() { _, _ = tc.Write(payload) }()
+	select {
+	case err = <-raw.feedErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("feed never reported a full buffer")
+	}
+	if !strings.Contains(err.Error(), "tls inbound buffer full") {
+		t.Fatalf("feed error: %v", err)
+	}
+	if n := conn.InboundBuffered(); n > limit+tlsReadBufferSize {
+		t.Fatalf("%d bytes of plaintext buffered, limit %d", n, limit)
+	}
+	conn.transport.mutex.Lock()
+	pending := len(conn.transport.inbound)
+	conn.transport.mutex.Unlock()
+	if pending > limit {
+		t.Fatalf("%d bytes of ciphertext pending, limit %d", pending, limit)
+	}
+
+	// 读走明文后恢复解密，剩余数据全部送达
+	received := 0
+	deadline := time.Now().Add(5 * time.Second)
+	for received < len(payload) && time.Now().Before(deadline) {
+		raw.loop.Lock()
+		data, _ := conn.Next(-1)
+		raw.loop.Unlock()
+		received += len(data)
+		if len(data) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if received != len(payload) {
+		t.Fatalf("received %d of %d bytes after draining", received, len(payload))
+	}
+}
+
+func TestGNetServerRateLimit(t *testing.T) {
+	dialLimit := func(t *testing.T, limit RateLimit) (*WsClient, chan string, chan GnetContext) {
+		received := make(chan string, 64)
+		connected := make(chan GnetContext, 1)
+		util := NewGNetUtil(WithRateLimit(limit))
+		_, addr := startTestServer(t, WithGNetUtil(util),
+			WithOnConnect(func(ctx GnetContext) { connected <- ctx }),
+			WithOnMessage(func(ctx GnetContext, message []byte) { received <- string(message) }))
+		client, err := DialWs(context.Background(), "ws://"+addr+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = client.Close() })
+		return client, received, connected
+	}
+	dial := func(t *testing.T, action LimitAction) (*WsClient, chan string, chan GnetContext) {
+		client, received, connected := dialLimit(t, RateLimit{MessagesPerSecond: 10, MessageBurst: 2, Action: action})
+		for _, msg := range []string{"1", "2", "3", "4"} {
+			if err := client.Write([]byte(msg)); err != nil {
+				// 超限关闭时服务端可能在后续写入前已断开连接
+				if action == LimitClose {
+					break
+				}
+				t.Fatal(err)
+			}
+		}
+		return client, received, connected
+	}
+	collect := func(received chan string, wait time.Duration) []string {
+		var got []string
+		timeout := time.After(wait)
+		for {
+			select {
+			case msg := <-received:
+				got = append(got, msg)
+			case <-timeout:
+				return got
+			}
+		}
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		_, received, connected := dial(t, LimitDrop)
+		ctx := <-connected
+		// 客户端的多次写入可能分多个批次到达，令牌可能已少量恢复
+		got := collect(received, 300*time.Millisecond)
+		stats := ctx.(*WSContext).InboundStats()
+		if stats.Messages != 4 || stats.Dropped+uint64(len(got)) != 4 || stats.Dropped == 0 {
+			t.Fatalf("got %v, stats %+v", got, stats)
+		}
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		_, received, connected := dial(t, LimitDelay)
+		ctx := <-connected
+		got := collect(received, 500*time.Millisecond)
+		if strings.Join(got, "") != "1234" {
+			t.Fatalf("got %v", got)
+		}
+		if stats := ctx.(*WSContext).InboundStats(); stats.Dropped != 0 || stats.Delayed == 0 {
+			t.Fatalf("stats %+v", stats)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		_, received, connected := dial(t, LimitClose)
+		ctx := <-connected
+		got := collect(received, 300*time.Millisecond)
+		if len(got) >= 4 || ctx.(*WSContext).InboundStats().Limited == 0 {
+			t.Fatalf("got %v, stats %+v", got, ctx.(*WSContext).InboundStats())
+		}
+	})
+
+	// flood 分两批发送，第二批到达时积压已超过上限
+	flood := func(t *testing.T, client *WsClient) {
+		payload := strings.Repeat("a", 32)
+		for batch := 0; batch < 2; batch++ {
+			for i := 0; i < 10; i++ {
+				if err := client.Write([]byte(payload)); err != nil {
+					return
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	overflow := RateLimit{MessagesPerSecond: 1, MessageBurst: 1, Action: LimitDelay, MaxDelayedBytes: 64}
+
+	t.Run("delay overflow drop", func(t *testing.T) {
+		client, _, connected := dialLimit(t, overflow)
+		ctx := <-connected
+		flood(t, client)
+		if stats := ctx.(*WSContext).InboundStats(); stats.Dropped == 0 || stats.Limited == 0 {
+			t.Fatalf("stats %+v", stats)
+		}
+	})
+
+	t.Run("delay overflow close", func(t *testing.T) {
+		limit := overflow
+		limit.DelayOverflow = LimitClose
+		client, _, connected := dialLimit(t, limit)
+		<-connected
+		flood(t, client)
+		deadline := time.Now().Add(2 * time.Second)
+		for client.Write([]byte("x")) == nil {
+			if time.Now().After(deadline) {
+				t.Fatal("connection not closed after delay buffer overflow")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+}
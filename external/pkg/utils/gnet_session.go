@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"errors"
+	"github.com/panjf2000/gnet/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connIDSeq 全局连接ID序列，进程内单调递增
+var connIDSeq atomic.Uint64
+
+// connMeta 连接级元数据与属性存储，由 TCPContext 和 WSContext 共享
+type connMeta struct {
+	id          uint64
+	connectedAt time.Time
+	lastRead    atomic.Int64 // UnixNano
+	lastWrite   atomic.Int64 // UnixNano
+	remoteAddr  atomic.Value // net.Addr，经 PROXY 头部或可信代理修正后的客户端地址
+	peerAddr    atomic.Value // net.Addr，套接字对端地址
+	attrs       sync.Map
+	released    atomic.Bool
+}
+
+// ConnAttrs 连接元数据与属性访问，TCPContext、WSContext 等内置上下文均实现该接口，
+// 可通过类型断言从 GnetContext 中取得
+type ConnAttrs interface {
+	// ID 连接唯一标识
+	ID() uint64
+	// ConnectedAt 连接建立时间
+	ConnectedAt() time.Time
+	// LastActive 最近一次读写时间
+	LastActive() time.Time
+	// RemoteAddr 远端地址
+	RemoteAddr() net.Addr
+
+	// Set 设置连接属性，并发安全
+	Set(key string, value any)
+	// Get 获取连接属性
+	Get(key string) (any, bool)
+	// Delete 删除连接属性
+	Delete(key string)
+}
+
+// errNoConnAttrs 上下文未实现 ConnAttrs，无法按连接 ID 管理
+var errNoConnAttrs = errors.New("context does not implement ConnAttrs")
+
+// connID 返回连接 ID，上下文未实现 ConnAttrs 时返回 false
+func connID(ctx GnetContext) (uint64, bool) {
+	attrs, ok := ctx.(ConnAttrs)
+	if !ok {
+		return 0, false
+	}
+	return attrs.ID(), true
+}
+
+// metaHolder 用于从 GnetContext 中取出连接元数据
+type metaHolder interface {
+	meta() *connMeta
+}
+
+func (m *connMeta) meta() *connMeta {
+	return m
+}
+
+// initMeta 初始化连接元数据，c 为空时远端地址在首次流量时绑定
+func (m *connMeta) initMeta(c gnet.Conn) {
+	m.id = connIDSeq.Add(1)
+	m.connectedAt = time.Now()
+	m.lastRead.Store(m.connectedAt.UnixNano())
+	m.lastWrite.Store(m.connectedAt.UnixNano())
+	if c != nil {
+		m.bindAddr(c)
+	}
+}
+
+// bindAddr 记录远端地址，gnet 的 RemoteAddr 并非并发安全，需在事件循环内调用
+func (m *connMeta) bindAddr(c gnet.Conn) {
+	if m.peerAddr.Load() != nil {
+		return
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		m.peerAddr.Store(addr)
+		m.remoteAddr.CompareAndSwap(nil, addr)
+	}
+}
+
+// touchRead 刷新最近读取时间
+func (m *connMeta) touchRead() {
+	m.lastRead.Store(time.Now().UnixNano())
+}
+
+// touchWrite 刷新最近写入时间
+func (m *connMeta) touchWrite() {
+	m.lastWrite.Store(time.Now().UnixNano())
+}
+
+// ID 连接唯一标识
+func (m *connMeta) ID() uint64 {
+	return m.id
+}
+
+// ConnectedAt 连接建立时间
+func (m *connMeta) ConnectedAt() time.Time {
+	return m.connectedAt
+}
+
+// LastActive 最近一次读写时间
+func (m *connMeta) LastActive() time.Time {
+	return time.Unix(0, max(m.lastRead.Load(), m.lastWrite.Load()))
+}
+
+// LastRead 最近一次读取时间
+func (m *connMeta) LastRead() time.Time {
+	return time.Unix(0, m.lastRead.Load())
+}
+
+// LastWrite 最近一次写入时间
+func (m *connMeta) LastWrite() time.Time {
+	return time.Unix(0, m.lastWrite.Load())
+}
+
+// RemoteAddr 客户端地址，可在任意协程中调用；位于代理之后时为代理上报的真实地址
+func (m *connMeta) RemoteAddr() net.Addr {
+	addr, _ := m.remoteAddr.Load().(net.Addr)
+	return addr
+}
+
+// PeerAddr 套接字对端地址，位于代理之后时为代理自身的地址
+func (m *connMeta) PeerAddr() net.Addr {
+	addr, _ := m.peerAddr.Load().(net.Addr)
+	return addr
+}
+
+// Set 设置连接属性
+func (m *connMeta) Set(key string, value any) {
+	m.attrs.Store(key, value)
+}
+
+// Get 获取连接属性
+func (m *connMeta) Get(key string) (any, bool) {
+	return m.attrs.Load(key)
+}
+
+// Delete 删除连接属性
+func (m *connMeta) Delete(key string) {
+	m.attrs.Delete(key)
+}
+
+// GetAs 按类型获取连接属性，上下文未实现 ConnAttrs、属性不存在或类型不匹配时返回零值和 false
+func GetAs[T any](ctx GnetContext, key string) (T, bool) {
+	var zero T
+	attrs, ok := ctx.(ConnAttrs)
+	if !ok {
+		return zero, false
+	}
+	value, ok := attrs.Get(key)
+	if !ok {
+		return zero, false
+	}
+	t, ok := value.(T)
+	if !ok {
+		return zero, false
+	}
+	return t, true
+}
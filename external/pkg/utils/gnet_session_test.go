@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"github.com/panjf2000/gnet/v2"
+	"testing"
+	"time"
+)
+
+// plainCtx 只实现 GnetContext 的外部上下文
+type plainCtx struct{}
+
+func (plainCtx) GetType() string      { return "plain" }
+func (plainCtx) Close() error         { return nil }
+func (plainCtx) Conn() gnet.Conn      { return nil }
+func (plainCtx) Write(_ []byte) error { return nil }
+
+func TestConnAttrs(t *testing.T) {
+	before := time.Now()
+	util := NewGNetUtil()
+	ctx := util.NewWsCtx()
+	other := util.NewWsCtx()
+
+	attrs, ok := ctx.(ConnAttrs)
+	if !ok {
+		t.Fatal("WSContext does not implement ConnAttrs")
+	}
+	if attrs.ID() == 0 || attrs.ID() == other.(ConnAttrs).ID() {
+		t.Fatalf("ids %d and %d are not unique", attrs.ID(), other.(ConnAttrs).ID())
+	}
+	if attrs.ConnectedAt().Before(before) || attrs.ConnectedAt().After(time.Now()) {
+		t.Fatalf("connected at %v", attrs.ConnectedAt())
+	}
+	if attrs.LastActive().Before(attrs.ConnectedAt()) {
+		t.Fatalf("last active %v before connected at %v", attrs.LastActive(), attrs.ConnectedAt())
+	}
+
+	attrs.Set("user", "u1")
+	attrs.Set("count", 3)
+	if v, ok := attrs.Get("user"); !ok || v != "u1" {
+		t.Fatalf("get user = %v, %v", v, ok)
+	}
+	if v, ok := GetAs[string](ctx, "user"); !ok || v != "u1" {
+		t.Fatalf("GetAs[string] = %q, %v", v, ok)
+	}
+	if _, ok := GetAs[string](ctx, "count"); ok {
+		t.Fatal("GetAs matched a value of a different type")
+	}
+	attrs.Delete("user")
+	if _, ok := attrs.Get("user"); ok {
+		t.Fatal("attribute not deleted")
+	}
+	if _, ok := GetAs[string](plainCtx{}, "user"); ok {
+		t.Fatal("GetAs on a context without ConnAttrs")
+	}
+}
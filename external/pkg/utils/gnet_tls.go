@@ -0,0 +1,452 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/panjf2000/gnet/v2"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tlsReadBufferSize = 16 * 1024 // 单个 TLS 记录最大 16KB
+
+// WithTLSConfig 开启 TLS，HandleWsTraffic、HandleHttpTraffic 与 TCP 编解码器处理的均为解密后的数据。
+// 证书热更新与 SNI 可使用 CertStore.TLSConfig 生成配置
+func WithTLSConfig(config *tls.Config) GNetUtilOption {
+	return func(c *GNetConfig) {
+		c.TLSConfig = config
+	}
+}
+
+// tlsTransport 内存传输层：密文由事件循环写入，供 crypto/tls 在独立协程中阻塞读取；
+// 加密后的数据通过 AsyncWrite 交回事件循环发送
+type tlsTransport struct {
+	raw     gnet.Conn
+	local   net.Addr
+	remote  net.Addr
+	limit   int // 待解密密文上限，超出部分留在 gnet 的读缓冲区
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	inbound []byte
+	full    bool // 曾因达到上限而停止接收，读出后需唤醒事件循环继续 Feed
+	closed  bool
+}
+
+// feed 写入不超过剩余空间的密文，返回实际写入的字节数
+func (t *tlsTransport) feed(c gnet.Conn, n int) (int, error) {
+	t.mutex.Lock()
+	room := t.limit - len(t.inbound)
+	if n >= room {
+		t.full = true
+	}
+	t.mutex.Unlock()
+	if n = min(n, room); n <= 0 {
+		return 0, nil
+	}
+	data, err := c.Next(n)
+	if err != nil {
+		return 0, err
+	}
+	t.mutex.Lock()
+	t.inbound = append(t.inbound, data...)
+	t.mutex.Unlock()
+	t.cond.Signal()
+	return len(data), nil
+}
+
+func (t *tlsTransport) Read(p []byte) (int, error) {
+	t.mutex.Lock()
+	for len(t.inbound) == 0 && !t.closed {
+		t.cond.Wait()
+	}
+	if len(t.inbound) == 0 {
+		t.mutex.Unlock()
+		return 0, io.EOF
+	}
+	n := copy(p, t.inbound)
+	t.inbound = t.inbound[n:]
+	// 腾出空间后唤醒事件循环，把留在 gnet 读缓冲区的密文继续交给 TLS 层
+	wake := t.full && len(t.inbound) < t.limit
+	if wake {
+		t.full = false
+	}
+	t.mutex.Unlock()
+	if wake {
+		_ = t.raw.Wake(nil)
+	}
+	return n, nil
+}
+
+func (t *tlsTransport) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	closed := t.closed
+	t.mutex.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	// crypto/tls 会复用写缓冲区，异步写出前需要复制
+	if err := t.raw.AsyncWrite(bytes.Clone(p), nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *tlsTransport) Close() error {
+	t.mutex.Lock()
+	t.closed = true
+	t.mutex.Unlock()
+	t.cond.Broadcast()
+	return nil
+}
+
+func (t *tlsTransport) LocalAddr() net.Addr              { return t.local }
+func (t *tlsTransport) RemoteAddr() net.Addr             { return t.remote }
+func (t *tlsTransport) SetDeadline(time.Time) error      { return nil }
+func (t *tlsTransport) SetReadDeadline(time.Time) error  { return nil }
+func (t *tlsTransport) SetWriteDeadline(time.Time) error { return nil }
+
+// TLSConn 在 gnet.Conn 之上提供明文读写视图，读相关方法需在事件循环中调用，
+// Write/AsyncWrite 可在任意协程中调用。
+// 未读明文与待解密密文各不超过 limit：明文达到上限时暂停解密，直到处理函数读走数据；
+// gnet 无法暂停读取，对端在暂停期间继续发送且积压超过 limit 时关闭连接
+type TLSConn struct {
+	gnet.Conn
+	transport *tlsTransport
+	tls       *tls.Conn
+	limit     int
+	mutex     sync.Mutex
+	cond      *sync.Cond // 明文被读走或连接关闭时通知解密协程
+	plain     []byte
+	off       int
+	stopped   bool
+	err       error
+}
+
+// NewTLSConn 为连接创建 TLS 层，需在事件循环中调用；
+// 之后每次 OnTraffic 先调用 Feed，再以返回的连接作为明文连接处理
+func (g *GNetUtil) NewTLSConn(c gnet.Conn) (*TLSConn, error) {
+	if g.config.TLSConfig == nil {
+		return nil, errors.New("tls not configured")
+	}
+	// 上限取单条消息与单个 HTTP 请求中较大者，再留出一个 TLS 记录的余量
+	limit := int(max(g.config.MaxMessageSize, int64(g.config.MaxHTTPHeaderSize)+g.config.MaxHTTPBodySize)) + tlsReadBufferSize
+	transport := &tlsTransport{
+		raw:    c,
+		local:  c.LocalAddr(),
+		remote: c.RemoteAddr(),
+		limit:  limit,
+	}
+	transport.cond = sync.NewCond(&transport.mutex)
+	t := &TLSConn{
+		Conn:      c,
+		transport: transport,
+		tls:       tls.Server(transport, g.config.TLSConfig),
+		limit:     limit,
+	}
+	t.cond = sync.NewCond(&t.mutex)
+	go t.run()
+	return t, nil
+}
+
+// run 握手并持续解密，每解出一段明文唤醒一次事件循环，未读明文达到上限时暂停
+func (t *TLSConn) run() {
+	err := t.tls.Handshake()
+	if err == nil {
+		buf := make([]byte, tlsReadBufferSize)
+		for t.wait() {
+			var n int
+			n, err = t.tls.Read(buf)
+			if n > 0 {
+				t.mutex.Lock()
+				t.plain = append(t.plain, buf[:n]...)
+				t.mutex.Unlock()
+				_ = t.Conn.Wake(nil)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	t.mutex.Lock()
+	t.err = err
+	t.mutex.Unlock()
+	if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		slog.Debug("tls connection failed", "remote", t.transport.remote, "error", err)
+	}
+	_ = t.Conn.Close()
+}
+
+// wait 等待未读明文低于上限，连接关闭时返回 false
+func (t *TLSConn) wait() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for len(t.plain)-t.off >= t.limit && !t.stopped {
+		t.cond.Wait()
+	}
+	return !t.stopped
+}
+
+// Feed 将底层连接收到的密文交给 TLS 层，需在 OnTraffic 中调用；
+// 解密暂停期间剩余密文留在 gnet 读缓冲区，积压超过上限时返回错误
+func (t *TLSConn) Feed() error {
+	if buffered := t.Conn.InboundBuffered(); buffered > 0 {
+		n, err := t.transport.feed(t.Conn, buffered)
+		if err != nil {
+			return fmt.Errorf("read tls data failed: %v", err)
+		}
+		if buffered-n > t.limit {
+			return fmt.Errorf("tls inbound buffer full: %d bytes pending", buffered-n)
+		}
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.err != nil && !errors.Is(t.err, io.EOF) {
+		return t.err
+	}
+	return nil
+}
+
+// ConnectionState 握手结果，包括 SNI 与 ALPN 协商的协议
+func (t *TLSConn) ConnectionState() tls.ConnectionState {
+	return t.tls.ConnectionState()
+}
+
+func (t *TLSConn) Read(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.off >= len(t.plain) {
+		return 0, io.EOF
+	}
+	n := copy(p, t.plain[t.off:])
+	t.discard(n)
+	return n, nil
+}
+
+func (t *TLSConn) Peek(n int) ([]byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	buffered := len(t.plain) - t.off
+	if n > buffered {
+		return nil, io.ErrShortBuffer
+	}
+	if n <= 0 {
+		n = buffered
+	}
+	return t.plain[t.off : t.off+n], nil
+}
+
+func (t *TLSConn) Next(n int) ([]byte, error) {
+	buf, err := t.Peek(n)
+	if err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	t.discard(len(buf))
+	t.mutex.Unlock()
+	return buf, nil
+}
+
+func (t *TLSConn) Discard(n int) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	n = min(n, len(t.plain)-t.off)
+	t.discard(n)
+	return n, nil
+}
+
+// discard 读空后丢弃底层数组而不是复用，保证已返回的 Peek/Next 切片不会被后续解密数据覆盖
+func (t *TLSConn) discard(n int) {
+	t.off += n
+	if t.off >= len(t.plain) {
+		t.plain, t.off = nil, 0
+	}
+	if n > 0 {
+		t.cond.Signal()
+	}
+}
+
+func (t *TLSConn) InboundBuffered() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.plain) - t.off
+}
+
+func (t *TLSConn) Write(p []byte) (int, error) {
+	return t.tls.Write(p)
+}
+
+func (t *TLSConn) Writev(bs [][]byte) (int, error) {
+	return t.tls.Write(bytes.Join(bs, nil))
+}
+
+// AsyncWrite 加密后排队发送，callback 在密文写出后于事件循环中执行
+func (t *TLSConn) AsyncWrite(buf []byte, callback gnet.AsyncCallback) error {
+	if _, err := t.tls.Write(buf); err != nil {
+		return err
+	}
+	if callback == nil {
+		return nil
+	}
+	return t.Conn.AsyncWrite(nil, callback)
+}
+
+func (t *TLSConn) Close() error {
+	t.shutdown()
+	return t.Conn.Close()
+}
+
+// shutdown 关闭内存传输层，结束解密协程
+func (t *TLSConn) shutdown() {
+	t.mutex.Lock()
+	t.stopped = true
+	t.mutex.Unlock()
+	t.cond.Broadcast()
+	_ = t.transport.Close()
+}
+
+// TLSState 获取上下文的 TLS 握手结果，非 TLS 连接返回 false
+func TLSState(ctx GnetContext) (tls.ConnectionState, bool) {
+	t, ok := ctx.Conn().(*TLSConn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return t.ConnectionState(), true
+}
+
+// certEntry 证书文件及其加载结果
+type certEntry struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// CertStore 证书仓库，按 SNI 选择证书并支持文件变更后热更新
+type CertStore struct {
+	mutex sync.RWMutex
+	certs map[string]*certEntry // 小写域名，支持 *.example.com，空字符串为默认证书
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// NewCertStore 创建证书仓库
+func NewCertStore() *CertStore {
+	return &CertStore{
+		certs: make(map[string]*certEntry),
+		stop:  make(chan struct{}),
+	}
+}
+
+// Add 添加证书，serverName 为空表示默认证书，可使用 *.example.com 形式的通配域名
+func (s *CertStore) Add(serverName, certFile, keyFile string) error {
+	entry := &certEntry{certFile: certFile, keyFile: keyFile}
+	if err := entry.load(); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.certs[strings.ToLower(serverName)] = entry
+	s.mutex.Unlock()
+	return nil
+}
+
+func (e *certEntry) load() error {
+	info, err := os.Stat(e.certFile)
+	if err != nil {
+		return fmt.Errorf("stat certificate failed: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate failed: %v", err)
+	}
+	e.cert = &cert
+	e.modTime = info.ModTime()
+	return nil
+}
+
+// Reload 重新加载文件有变更的证书，加载失败时保留旧证书
+func (s *CertStore) Reload() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var errs []error
+	for name, entry := range s.certs {
+		info, err := os.Stat(entry.certFile)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !info.ModTime().After(entry.modTime) {
+			continue
+		}
+		reloaded := &certEntry{certFile: entry.certFile, keyFile: entry.keyFile}
+		if err = reloaded.load(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.certs[name] = reloaded
+		slog.Info("certificate reloaded", "serverName", name, "file", entry.certFile)
+	}
+	return errors.Join(errs...)
+}
+
+// Watch 定期检查证书文件并热更新，Stop 后停止
+func (s *CertStore) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if err := s.Reload(); err != nil {
+					slog.Error("reload certificate failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止证书文件检查
+func (s *CertStore) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+// GetCertificate 按 SNI 选择证书：精确匹配、通配匹配、默认证书
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if entry, ok := s.certs[name]; ok && name != "" {
+		return entry.cert, nil
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if entry, ok := s.certs["*"+name[i:]]; ok {
+			return entry.cert, nil
+		}
+	}
+	if entry, ok := s.certs[""]; ok {
+		return entry.cert, nil
+	}
+	return nil, fmt.Errorf("no certificate for server name %q", hello.ServerName)
+}
+
+// TLSConfig 生成使用本仓库证书的 TLS 配置，nextProtos 为 ALPN 支持的协议，如 http/1.1
+func (s *CertStore) TLSConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: s.GetCertificate,
+		NextProtos:     nextProtos,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
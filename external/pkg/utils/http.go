@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/bravpei/webtools/external/pkg/trace"
 	"io"
 	"log/slog"
 	"net"
@@ -144,12 +145,13 @@ func (w *HttpClientWrapper) request(method, api string, header map[string]string
 		}
 	}
 
-	// 设置通用header
+	// 设置通用header，传入的 ctx 带链路 ID 时透传给下游
 	req.Header.Set("Content-Type", "application/json")
+	trace.Inject(req.Context(), req.Header)
 	for k, v := range header {
 		req.Header.Set(k, v)
 	}
-	slog.Debug("发送HTTP请求", "method", method, "url", apiURL, "body", string(body))
+	slog.DebugContext(req.Context(), "发送HTTP请求", "method", method, "url", apiURL, "body", string(body))
 	return w.doWithRetry(req)
 }
 
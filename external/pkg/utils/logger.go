@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"fmt"
+	"github.com/bravpei/webtools/external/pkg/trace"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -74,6 +75,11 @@ func (h *CustomHandler) Handle(ctx context.Context, r slog.Record) error {
 		r.Message,
 	)
 
+	// 使用 slog.*Context 记录时自动附加链路 ID
+	if traceID := trace.FromContext(ctx); traceID != "" {
+		logMsg += formatAttr(slog.String("trace_id", traceID))
+	}
+
 	// 添加预挂载属性（来自 WithAttrs）
 	for _, attr := range h.preAttrs {
 		logMsg += formatAttr(attr)
@@ -5,6 +5,7 @@ import (
 	"github.com/panjf2000/ants/v2"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -127,7 +128,7 @@ type TimingWheel struct {
 	interval         uint32
 	scale            uint64
 	nodes            []*node
-	current          uint64
+	current          atomic.Uint64 // 当前槽位，时间轮协程推进，AddTask 可在任意协程读取
 	stop             chan struct{}
 	status           timingWheelStatus
 	lock             sync.Mutex
@@ -201,14 +202,15 @@ func (tw *TimingWheel) run() {
 }
 
 func (tw *TimingWheel) tick() {
-	currentNode := tw.nodes[tw.current]
+	current := tw.current.Load()
+	currentNode := tw.nodes[current]
 	currentNode.lock.Lock()
 	tasks := currentNode.tasks
 	currentNode.tasks = nil
 	currentNode.lock.Unlock()
 
 	if len(tasks) == 0 {
-		tw.current = (tw.current + 1) % tw.scale
+		tw.current.Store((current + 1) % tw.scale)
 		return
 	}
 
@@ -224,12 +226,15 @@ func (tw *TimingWheel) tick() {
 		}
 	}()
 
-	tw.current = (tw.current + 1) % tw.scale
+	tw.current.Store((current + 1) % tw.scale)
 }
 
 // AddTask 添加定时任务
 func (tw *TimingWheel) AddTask(data any, handler TaskHandler, duration time.Duration) error {
-	if tw.status != running {
+	tw.lock.Lock()
+	status := tw.status
+	tw.lock.Unlock()
+	if status != running {
 		return fmt.Errorf("时间轮未启动")
 	}
 
@@ -252,7 +257,7 @@ func (tw *TimingWheel) AddTask(data any, handler TaskHandler, duration time.Dura
 		handler: handler,
 	}
 
-	node := tw.nodes[(tw.current+index)%tw.scale]
+	node := tw.nodes[(tw.current.Load()+index)%tw.scale]
 	node.lock.Lock()
 	node.tasks = append(node.tasks, t)
 	node.lock.Unlock()
@@ -332,7 +337,7 @@ func (tw *TimingWheel) handleTaskError(t *task, err error) {
 }
 
 func (tw *TimingWheel) reinsertTask(t *task) {
-	node := tw.nodes[(tw.current+1)%tw.scale]
+	node := tw.nodes[(tw.current.Load()+1)%tw.scale]
 	node.lock.Lock()
 	if tw.maxTasksPerSlot > 0 && len(node.tasks) >= tw.maxTasksPerSlot {
 		slog.Warn("槽位任务数超过限制", "maxTasks", tw.maxTasksPerSlot)